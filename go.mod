@@ -1,3 +1,15 @@
 module github.com/censync/go-validator
 
-go 1.14
+go 1.21
+
+require (
+	github.com/stretchr/testify v1.10.0
+	github.com/x88/null v2.1.2+incompatible
+)
+
+require (
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/lib/pq v1.12.3 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
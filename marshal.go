@@ -0,0 +1,231 @@
+package validator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// errorCodes holds the stable codes of the errors in the serialized
+// ErrorMap, the errors are restored by them when it is decoded.
+var errorCodes = []struct {
+	err  error
+	code string
+}{
+	{ErrZeroValue, "zero_value"},
+	{ErrMin, "min"},
+	{ErrMax, "max"},
+	{ErrLen, "len"},
+	{ErrRegexp, "regexp"},
+	{ErrUnsupported, "unsupported"},
+	{ErrBadParameter, "bad_parameter"},
+	{ErrUnknownTag, "unknown_tag"},
+	{ErrInvalid, "invalid"},
+	{ErrInvalidValue, "invalid_value"},
+	{ErrInvalidTypedValue, "invalid_typed_value"},
+	{ErrEqField, "eqfield"},
+	{ErrNeField, "nefield"},
+	{ErrGtField, "gtfield"},
+	{ErrLtField, "ltfield"},
+	{ErrExcluded, "excluded"},
+	{ErrCompare, "compare"},
+	{ErrNCompare, "ncompare"},
+	{ErrAliasCycle, "alias_cycle"},
+	{context.Canceled, "canceled"},
+	{context.DeadlineExceeded, "deadline_exceeded"},
+}
+
+// codeErrors is the reverse of errorCodes
+var codeErrors = func() map[string]error {
+	m := make(map[string]error, len(errorCodes))
+	for _, c := range errorCodes {
+		m[c.code] = c.err
+	}
+	return m
+}()
+
+// codeOf returns the code of the error or an empty string. The
+// errors are compared with ==, which is safe for any error since
+// the known errors hold comparable values only.
+func codeOf(err error) string {
+	if e, ok := err.(*LocalizedErr); ok {
+		err = e.Err
+	}
+	if err == nil {
+		return ""
+	}
+	for _, c := range errorCodes {
+		if err == c.err {
+			return c.code
+		}
+	}
+	return ""
+}
+
+// errorJSON is a single error of the serialized ErrorMap
+type errorJSON struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule,omitempty"`
+	Param   string `json:"param,omitempty"`
+	Message string `json:"message"`
+	Code    string `json:"code,omitempty"`
+	// Attr, Value, Default and Placeholders are the details
+	// of a FieldError
+	Attr         string            `json:"attr,omitempty"`
+	Value        interface{}       `json:"value,omitempty"`
+	Default      string            `json:"default,omitempty"`
+	Placeholders map[string]string `json:"placeholders,omitempty"`
+	// Array is set for the errors of a field reporting an ErrorArray
+	Array bool `json:"array,omitempty"`
+}
+
+// newErrorJSON describes the error of the field
+func newErrorJSON(field string, err error, array bool) errorJSON {
+	e := errorJSON{Field: field, Message: err.Error(), Array: array}
+	switch err := err.(type) {
+	case *FieldError:
+		e.Rule = err.Rule
+		e.Param = err.Param
+		e.Code = codeOf(err.Err)
+		e.Attr = err.Attr
+		e.Value = err.Value
+		e.Default = err.Default
+		e.Placeholders = err.Placeholders
+	case RuleErr:
+		e.Rule = err.Rule
+		e.Code = codeOf(err.Err)
+	default:
+		e.Code = codeOf(err)
+	}
+	return e
+}
+
+// error restores the described error
+func (e errorJSON) error() error {
+	err, known := codeErrors[e.Code]
+	switch {
+	case e.Default != "":
+		fe := &FieldError{
+			Path:         e.Field,
+			Attr:         e.Attr,
+			Rule:         e.Rule,
+			Param:        e.Param,
+			Value:        e.Value,
+			Default:      e.Default,
+			Placeholders: e.Placeholders,
+			Err:          err,
+		}
+		if !known {
+			fe.Err = errors.New(e.Default)
+		}
+		if e.Message != e.Default {
+			fe.Message = e.Message
+		}
+		return fe
+	case !known:
+		err = errors.New(e.Message)
+	case e.Message != err.Error():
+		err = &LocalizedErr{Err: err, Message: e.Message}
+	}
+
+	if e.Rule != "" {
+		return RuleErr{Rule: e.Rule, Err: err}
+	}
+	return err
+}
+
+// MarshalJSON encodes the errors as a list of objects with the field,
+// rule, param, message and code of every error in the path order of
+// the fields. The code identifies the builtin errors, e.g. "min".
+func (err ErrorMap) MarshalJSON() ([]byte, error) {
+	list := make([]errorJSON, 0, len(err))
+	for _, k := range err.Fields() {
+		if arr, ok := err[k].(ErrorArray); ok {
+			for _, e := range arr {
+				list = append(list, newErrorJSON(k, e, true))
+			}
+			continue
+		}
+		list = append(list, newErrorJSON(k, err[k], false))
+	}
+	return json.Marshal(list)
+}
+
+// UnmarshalJSON decodes the errors encoded by MarshalJSON. The builtin
+// errors are restored by their codes, so errors.Is works with them.
+func (err *ErrorMap) UnmarshalJSON(data []byte) error {
+	var list []errorJSON
+	if e := json.Unmarshal(data, &list); e != nil {
+		return e
+	}
+
+	m := make(ErrorMap, len(list))
+	for _, e := range list {
+		if !e.Array {
+			m[e.Field] = e.error()
+			continue
+		}
+		arr, _ := m[e.Field].(ErrorArray)
+		m[e.Field] = append(arr, e.error())
+	}
+	*err = m
+	return nil
+}
+
+// MarshalText encodes the errors as the Summary.
+func (err ErrorMap) MarshalText() ([]byte, error) {
+	return []byte(err.Summary()), nil
+}
+
+// ProblemContentType is the media type of the Problem
+const ProblemContentType = "application/problem+json"
+
+// Problem is an RFC 7807 problem details object with the
+// invalid-params extension listing the errors of the fields.
+type Problem struct {
+	Type          string         `json:"type,omitempty"`
+	Title         string         `json:"title"`
+	Status        int            `json:"status,omitempty"`
+	Detail        string         `json:"detail,omitempty"`
+	Instance      string         `json:"instance,omitempty"`
+	InvalidParams []InvalidParam `json:"invalid-params"`
+}
+
+// InvalidParam is an error of a field in the Problem
+type InvalidParam struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+	Rule   string `json:"rule,omitempty"`
+	Code   string `json:"code,omitempty"`
+}
+
+// Problem describes the errors as a 400 Bad Request problem. The
+// fields of the result may be changed before it is sent.
+func (err ErrorMap) Problem() *Problem {
+	p := &Problem{
+		Type:          "about:blank",
+		Title:         http.StatusText(http.StatusBadRequest),
+		Status:        http.StatusBadRequest,
+		InvalidParams: make([]InvalidParam, 0, len(err)),
+	}
+	err.Each(func(field string, err error) {
+		e := newErrorJSON(field, err, false)
+		p.InvalidParams = append(p.InvalidParams, InvalidParam{
+			Name:   e.Field,
+			Reason: e.Message,
+			Rule:   e.Rule,
+			Code:   e.Code,
+		})
+	})
+	return p
+}
+
+// WriteProblem writes the errors as an application/problem+json
+// response with the status of the problem.
+func (err ErrorMap) WriteProblem(w http.ResponseWriter) error {
+	p := err.Problem()
+	w.Header().Set("Content-Type", ProblemContentType)
+	w.WriteHeader(p.Status)
+	return json.NewEncoder(w).Encode(p)
+}
@@ -0,0 +1,165 @@
+package validator
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// PlaceholderErr is an error returned by validation rules which
+// provides additional placeholders for custom error messages,
+// e.g. {allowed} of the in rule. The validator reports Err itself.
+type PlaceholderErr struct {
+	Err          error
+	Placeholders map[string]string
+}
+
+// Error implements the error interface.
+func (e PlaceholderErr) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the wrapped error
+func (e PlaceholderErr) Unwrap() error {
+	return e.Err
+}
+
+// splitPlaceholders returns the error reported by the rule
+// and the placeholders it provides, if any
+func splitPlaceholders(err error) (error, map[string]string) {
+	if pe, ok := err.(PlaceholderErr); ok {
+		return pe.Err, pe.Placeholders
+	}
+	return err, nil
+}
+
+// noMask means the value is shown in messages as is
+const noMask = -1
+
+// message is a custom error message (msg_<rule>) of a rule
+type message struct {
+	template string
+	// static holds the placeholders known when the rules are compiled:
+	// {param}, {field}, {label} and the parameters of all field rules
+	// under the rule names, e.g. {min}
+	static map[string]string
+	// mask is the number of trailing characters of {value} shown,
+	// the rest is replaced by '*'; noMask shows the whole value
+	mask int
+}
+
+// newMessage prepares the custom message of the rule
+func newMessage(template, field string, t tag, tags tagList, mask int) *message {
+	msg := &message{
+		template: template,
+		static:   make(map[string]string, len(tags)+3),
+		mask:     mask,
+	}
+	for _, ft := range tags {
+		if !strings.HasPrefix(ft.Name, "msg_") {
+			msg.static[ft.Name] = ft.Param
+		}
+	}
+	msg.static["param"] = t.Param
+	msg.static["field"] = field
+	if _, exists := msg.static[tagLabel]; !exists {
+		msg.static[tagLabel] = field
+	}
+
+	return msg
+}
+
+// format replaces placeholders in the message. Placeholders which
+// are not known are left as is.
+func (msg *message) format(v interface{}, extra map[string]string) string {
+	return expand(msg.template, func(name string) (string, bool) {
+		return msg.lookup(name, v, extra)
+	})
+}
+
+// expand replaces placeholders in the template by the values
+// returned by lookup. Unknown placeholders are left as is.
+func expand(template string, lookup func(name string) (string, bool)) string {
+	if !strings.Contains(template, "{") {
+		return template
+	}
+
+	var (
+		b = strings.Builder{}
+		s = template
+	)
+	for {
+		start := strings.IndexByte(s, '{')
+		if start < 0 {
+			break
+		}
+		end := strings.IndexByte(s[start:], '}')
+		if end < 0 {
+			break
+		}
+		end += start
+
+		b.WriteString(s[:start])
+		name := s[start+1 : end]
+		if value, exists := lookup(name); exists {
+			b.WriteString(value)
+		} else {
+			b.WriteString(s[start : end+1])
+		}
+		s = s[end+1:]
+	}
+	b.WriteString(s)
+
+	return b.String()
+}
+
+// lookup returns the value of the placeholder
+func (msg *message) lookup(name string, v interface{}, extra map[string]string) (string, bool) {
+	if name == "value" {
+		return maskValue(v, msg.mask), true
+	}
+	if value, exists := msg.static[name]; exists {
+		return value, true
+	}
+	value, exists := extra[name]
+	return value, exists
+}
+
+// maskValue formats the value showing only the last keep characters
+func maskValue(v interface{}, keep int) string {
+	if v == nil {
+		return ""
+	}
+
+	s := fmt.Sprint(v)
+	if keep == noMask {
+		return s
+	}
+
+	n := utf8.RuneCountInString(s)
+	if keep >= n {
+		return s
+	}
+
+	runes := []rune(s)
+	return strings.Repeat("*", n-keep) + string(runes[n-keep:])
+}
+
+// parseMask parses the mask tag parameter: the number of trailing
+// characters shown, or all characters hidden if empty
+func parseMask(tags tagList) (int, error) {
+	t, exists := tags.getByName(tagMask)
+	if !exists {
+		return noMask, nil
+	}
+	if t.Param == "" || t.Param == "true" {
+		return 0, nil
+	}
+
+	keep, err := strconv.Atoi(t.Param)
+	if err != nil || keep < 0 {
+		return 0, ErrBadParameter
+	}
+	return keep, nil
+}
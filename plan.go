@@ -0,0 +1,366 @@
+package validator
+
+import (
+	"reflect"
+	"sync"
+)
+
+// planKey identifies a compiled struct plan. The same struct type
+// may be validated with different tag names, so both of them are
+// part of the key.
+type planKey struct {
+	typ     reflect.Type
+	tagName string
+}
+
+// planCache stores compiled struct plans. It is safe for concurrent use.
+type planCache struct {
+	mu sync.RWMutex
+	// gen is the configuration generation of the owner validator
+	// the plans were built with
+	gen   uint64
+	plans map[planKey]*structPlan
+}
+
+// newPlanCache creates an empty plan cache
+func newPlanCache() *planCache {
+	return &planCache{plans: make(map[planKey]*structPlan)}
+}
+
+// get returns a cached plan if exists and is up to date
+func (c *planCache) get(k planKey, gen uint64) (*structPlan, bool) {
+	c.mu.RLock()
+	p, ok := c.plans[k]
+	ok = ok && c.gen == gen
+	c.mu.RUnlock()
+	return p, ok
+}
+
+// set stores a plan built with the configuration generation. Plans
+// of older generations are dropped.
+func (c *planCache) set(k planKey, p *structPlan, gen uint64) {
+	c.mu.Lock()
+	if gen > c.gen {
+		c.gen = gen
+		c.plans = make(map[planKey]*structPlan)
+	}
+	if gen == c.gen {
+		c.plans[k] = p
+	}
+	c.mu.Unlock()
+}
+
+// structPlan is a list of struct fields to be validated together
+// with their parsed tags, built once per struct type.
+type structPlan struct {
+	fields []fieldPlan
+	// value is the plan of a slice, array or map of structs
+	// validated at the top level
+	value *valuePlan
+}
+
+// fieldPlan contains everything needed to validate a single field
+type fieldPlan struct {
+	index []int  // field index sequence, longer for promoted fields
+	name  string // key in the ErrorMap: field name or attr alias
+	// err is the error found while parsing the tags of the field
+	err   error
+	value *valuePlan
+}
+
+// valuePlan holds the rules of a value: a struct field or, after
+// the dive marker, the elements of a slice, array or map.
+type valuePlan struct {
+	// tagged is true if the value has its own validation rules
+	tagged bool
+	rules  ruleList
+	// rulesErr is the error found while resolving the rules
+	rulesErr error
+	// elem is the plan of the elements, if they need validation
+	elem *valuePlan
+	// keys is the plan of the map keys, if they need validation
+	keys *valuePlan
+	// promoted is true for embedded structs whose fields are
+	// validated as the fields of the parent
+	promoted bool
+}
+
+// planFor returns the plan for the struct type, building it
+// on the first call.
+func (mv *Validator) planFor(st reflect.Type) *structPlan {
+	var (
+		owner   = mv.owner()
+		gen     = owner.generation()
+		tagName = mv.config().tagName
+		key     = planKey{typ: st, tagName: tagName}
+	)
+	if p, ok := owner.plans.get(key, gen); ok {
+		return p
+	}
+
+	p := mv.buildPlan(st, tagName)
+	owner.plans.set(key, p, gen)
+	return p
+}
+
+// buildPlan walks the struct type fields and parses their tags
+func (mv *Validator) buildPlan(st reflect.Type, tagName string) *structPlan {
+	if st.Kind() != reflect.Struct {
+		return &structPlan{value: mv.buildValuePlan(nil, "", st, []tagList{nil})}
+	}
+
+	p := &structPlan{fields: make([]fieldPlan, 0, st.NumField())}
+	mv.collectFields(p, st, st, tagName, nil, map[reflect.Type]bool{st: true})
+	p.fields = dominantFields(p.fields)
+
+	return p
+}
+
+// collectFields adds the plans of the struct type fields to p. Fields
+// of embedded structs are promoted to the root struct type the same
+// way encoding/json does, unless the embedded field has an attr alias.
+func (mv *Validator) collectFields(p *structPlan, root, st reflect.Type, tagName string, index []int, visited map[reflect.Type]bool) {
+	nfields := st.NumField()
+	for i := 0; i < nfields; i++ {
+		var (
+			sf = st.Field(i)
+			fp = fieldPlan{index: append(index[:len(index):len(index)], i), name: sf.Name}
+		)
+
+		// rules registered for the field take precedence over its tag
+		tag, rules := sf.Tag.Get(tagName), mv.lookupStructRules(st, sf.Name)
+		if rules == "-" || (tag == "-" && rules == "") {
+			continue
+		}
+		if tag == "-" {
+			tag = ""
+		}
+
+		// type of the embedded struct, if any
+		et := sf.Type
+		if et.Kind() == reflect.Ptr {
+			et = et.Elem()
+		}
+		embedded := sf.Anonymous && et.Kind() == reflect.Struct && !mv.isLeaf(et)
+
+		// unexported fields can't be read, but the exported
+		// fields of embedded structs can
+		if sf.PkgPath != "" && !embedded {
+			continue
+		}
+
+		if tag == "" && rules == "" && !mv.walkable(sf.Type) {
+			continue
+		}
+
+		// parse tags on the highest level to pass further
+		tags, err := mv.parseFieldTags(tag, rules)
+		if err != nil {
+			fp.err = err
+			p.fields = append(p.fields, fp)
+			continue
+		}
+
+		// custom field alias
+		nameTag, hasAttr := tags.getByName(tagAttr)
+		if hasAttr {
+			fp.name = nameTag.Param
+		}
+
+		if embedded && !hasAttr {
+			// embedded struct types may refer to themselves
+			if !visited[et] {
+				visited[et] = true
+				mv.collectFields(p, root, et, tagName, fp.index, visited)
+				delete(visited, et)
+			}
+			// rules of the embedded field itself still apply,
+			// e.g. notempty of a pointer
+			if len(tags) == 0 || sf.PkgPath != "" {
+				continue
+			}
+			fp.value = mv.buildValuePlan(root, fp.name, sf.Type, tags.split(tagDive))
+			fp.value.promoted = true
+			p.fields = append(p.fields, fp)
+			continue
+		}
+
+		if sf.PkgPath != "" {
+			continue
+		}
+
+		fp.value = mv.buildValuePlan(root, fp.name, sf.Type, tags.split(tagDive))
+		p.fields = append(p.fields, fp)
+	}
+}
+
+// parseFieldTags parses the tag of a field and merges the rules
+// registered for it into the tag
+func (mv *Validator) parseFieldTags(tag, rules string) (tagList, error) {
+	tags, err := mv.parseTags(tag)
+	if err != nil || rules == "" {
+		return tags, err
+	}
+
+	extra, err := mv.parseTags(rules)
+	if err != nil {
+		return nil, err
+	}
+	return tags.merge(extra), nil
+}
+
+// dominantFields drops the promoted fields hidden by the fields
+// with the same name at a shallower depth. Like encoding/json, it
+// drops the ambiguous promoted fields as well: the ones with the
+// same name at the shallowest depth.
+func dominantFields(fields []fieldPlan) []fieldPlan {
+	var (
+		depth = make(map[string]int, len(fields))
+		count = make(map[string]int, len(fields))
+	)
+	for _, fp := range fields {
+		switch d, ok := depth[fp.name]; {
+		case !ok || len(fp.index) < d:
+			depth[fp.name], count[fp.name] = len(fp.index), 1
+		case len(fp.index) == d:
+			count[fp.name]++
+		}
+	}
+
+	res := fields[:0]
+	for _, fp := range fields {
+		d := depth[fp.name]
+		if len(fp.index) == d && (d == 1 || count[fp.name] == 1) {
+			res = append(res, fp)
+		}
+	}
+	return res
+}
+
+// fieldByIndex returns the nested field of the struct value by
+// the index sequence. It reports false if an embedded pointer
+// on the way is nil.
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+// buildValuePlan compiles the rules of a value of the type belonging
+// to the parent struct type, nil at the top level. Every
+// section of the tags after the first one applies to the elements
+// of the previous level. Sections of maps may start with the key
+// rules enclosed in the keys and endkeys markers.
+func (mv *Validator) buildValuePlan(parent reflect.Type, name string, t reflect.Type, sections []tagList) *valuePlan {
+	// rules registered for the type go first
+	typeTags, typeErr := mv.typeRules(t)
+	if len(typeTags) > 0 {
+		sections = mergeSections(typeTags.split(tagDive), sections)
+	}
+
+	vp := &valuePlan{tagged: len(sections[0]) > 0}
+	vp.rules, vp.rulesErr = mv.compileRules(parent, name, t, sections[0])
+	if typeErr != nil {
+		vp.rulesErr = typeErr
+	}
+
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch {
+	case t.Kind() == reflect.Map && len(sections) > 1:
+		keyTags, valueTags, err := sections[1].splitKeys()
+		if err != nil {
+			vp.rulesErr = err
+			break
+		}
+		if len(keyTags) > 0 || mv.walkable(t.Key()) {
+			vp.keys = mv.buildValuePlan(parent, name, t.Key(), []tagList{keyTags})
+		}
+		vp.elem = mv.buildValuePlan(parent, name, t.Elem(), append([]tagList{valueTags}, sections[2:]...))
+	case t.Kind() == reflect.Map:
+		if mv.walkable(t.Key()) {
+			vp.keys = mv.buildValuePlan(parent, name, t.Key(), []tagList{nil})
+		}
+		if mv.walkable(t.Elem()) {
+			vp.elem = mv.buildValuePlan(parent, name, t.Elem(), []tagList{nil})
+		}
+	case t.Kind() != reflect.Slice && t.Kind() != reflect.Array:
+		if len(sections) > 1 && vp.rulesErr == nil {
+			vp.rulesErr = ErrUnsupported
+		}
+	case len(sections) > 1:
+		vp.elem = mv.buildValuePlan(parent, name, t.Elem(), sections[1:])
+	case mv.walkable(t.Elem()):
+		vp.elem = mv.buildValuePlan(parent, name, t.Elem(), []tagList{nil})
+	}
+
+	return vp
+}
+
+// mergeSections merges the sections of the field tags into the
+// sections of the rules registered for the type
+func mergeSections(base, sections []tagList) []tagList {
+	n := len(base)
+	if len(sections) > n {
+		n = len(sections)
+	}
+
+	res := make([]tagList, n)
+	for i := range res {
+		switch {
+		case i >= len(sections):
+			res[i] = base[i]
+		case i >= len(base):
+			res[i] = sections[i]
+		default:
+			res[i] = base[i].merge(sections[i])
+		}
+	}
+	return res
+}
+
+// typeRules returns the parsed rules registered for the type
+// or for the type it points to
+func (mv *Validator) typeRules(t reflect.Type) (tagList, error) {
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil {
+		return nil, nil
+	}
+
+	rules := mv.lookupTypeRules(t)
+	if rules == "" {
+		return nil, nil
+	}
+	return mv.parseTags(rules)
+}
+
+// walkable returns true if values of the type are validated even
+// if the field has no tag: nested structs, values of types with
+// registered rules and slices, arrays or maps of them.
+func (mv *Validator) walkable(t reflect.Type) bool {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if mv.lookupTypeRules(t) != "" {
+		return true
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		return !mv.isLeaf(t)
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return mv.walkable(t.Elem()) || (t.Kind() == reflect.Map && mv.walkable(t.Key()))
+	}
+	return false
+}
@@ -0,0 +1,280 @@
+package validator
+
+import (
+	"net"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sync/atomic"
+)
+
+// builtinRules is the bottom layer of every validator's rules
+var builtinRules = map[string]factory{
+	"notempty": adaptFunc(notZero),
+	"empty":    adaptFunc(notZero),
+	"len":      typedFactory(lengthRule, numParamFits),
+	"min":      typedFactory(minRule, numParamFits),
+	"max":      typedFactory(maxRule, numParamFits),
+	"in":       typedFactory(inRule, inParamFits),
+	"type":     adaptFunc(typeValid),
+	"compare":  adaptFactory(compareRule(false)),
+	"ncompare": adaptFactory(compareRule(true)),
+	"eqfield":  fieldRule(func(c int) bool { return c == 0 }, ErrEqField),
+	"nefield":  fieldRule(func(c int) bool { return c != 0 }, ErrNeField),
+	"gtfield":  fieldRule(func(c int) bool { return c == 1 }, ErrGtField),
+	"ltfield":  fieldRule(func(c int) bool { return c == -1 }, ErrLtField),
+
+	"required_if":      conditionRule(true, false, fieldsEqual),
+	"required_with":    conditionRule(false, false, anyFieldPresent),
+	"required_without": conditionRule(false, false, anyFieldAbsent),
+	"excluded_if":      conditionRule(true, true, fieldsEqual),
+}
+
+// builtinLeaves is the bottom layer of every validator's leaf types
+var builtinLeaves = map[reflect.Type]LeafFunc{
+	timeType:                    nil,
+	bigIntType:                  nil,
+	bigFloatType:                nil,
+	reflect.TypeOf(url.URL{}):   urlString,
+	reflect.TypeOf(net.IPNet{}): ipNetString,
+}
+
+// ruleRegexp is the name of the builtin regexp rule. It is not in
+// builtinRules since it resolves named patterns of the validator.
+const ruleRegexp = "regexp"
+
+// config is an immutable configuration layer of a Validator. It
+// is replaced as a whole on every change (copy-on-write), so
+// validations running concurrently always see a consistent state.
+type config struct {
+	tagName string
+	mode    Mode
+	// rules is a map of rule factories indexed by their name.
+	// A nil factory hides the rule inherited from the parent.
+	rules map[string]factory
+	// patterns is a map of named regular expressions
+	patterns map[string]*regexp.Regexp
+	// leaves is a map of struct types validated as values
+	leaves map[reflect.Type]LeafFunc
+	// structRules is a map of the rules registered for the
+	// fields of struct types, indexed by the field name
+	structRules map[reflect.Type]map[string]string
+	// typeRules is a map of the rules registered for types
+	typeRules map[reflect.Type]string
+	// aliases is a map of the rules the aliases stand for
+	aliases map[string]string
+	// wrappers is a map of the optional value types
+	wrappers map[reflect.Type]UnwrapFunc
+	// translator provides the messages of Translate
+	translator Translator
+}
+
+// isEmpty returns true if the layer doesn't override anything
+func (c *config) isEmpty() bool {
+	return len(c.rules) == 0 && len(c.patterns) == 0 && len(c.leaves) == 0 &&
+		len(c.structRules) == 0 && len(c.typeRules) == 0 && len(c.aliases) == 0 &&
+		len(c.wrappers) == 0 && c.translator == nil
+}
+
+// clone returns a copy of the layer which can be modified
+func (c *config) clone() *config {
+	n := &config{
+		tagName:  c.tagName,
+		mode:     c.mode,
+		rules:    make(map[string]factory, len(c.rules)+1),
+		patterns: make(map[string]*regexp.Regexp, len(c.patterns)+1),
+		leaves:   make(map[reflect.Type]LeafFunc, len(c.leaves)+1),
+
+		structRules: make(map[reflect.Type]map[string]string, len(c.structRules)+1),
+		typeRules:   make(map[reflect.Type]string, len(c.typeRules)+1),
+		aliases:     make(map[string]string, len(c.aliases)+1),
+		wrappers:    make(map[reflect.Type]UnwrapFunc, len(c.wrappers)+1),
+		translator:  c.translator,
+	}
+	for k, v := range c.rules {
+		n.rules[k] = v
+	}
+	for k, v := range c.patterns {
+		n.patterns[k] = v
+	}
+	for k, v := range c.leaves {
+		n.leaves[k] = v
+	}
+	for k, v := range c.structRules {
+		n.structRules[k] = v
+	}
+	for k, v := range c.typeRules {
+		n.typeRules[k] = v
+	}
+	for k, v := range c.aliases {
+		n.aliases[k] = v
+	}
+	for k, v := range c.wrappers {
+		n.wrappers[k] = v
+	}
+	return n
+}
+
+// config returns the current configuration layer of the validator
+func (mv *Validator) config() *config {
+	return mv.cfg.Load().(*config)
+}
+
+// update applies the change to a copy of the configuration layer
+// and publishes it. Cached plans of the validator and of the ones
+// extending it are invalidated.
+func (mv *Validator) update(change func(c *config)) {
+	mv.mu.Lock()
+	c := mv.config().clone()
+	change(c)
+	mv.cfg.Store(c)
+	atomic.AddUint64(&mv.gen, 1)
+	mv.mu.Unlock()
+}
+
+// generation returns the number of configuration changes of the
+// validator and its parents. Plans built with another one are stale.
+func (mv *Validator) generation() uint64 {
+	var gen uint64
+	for v := mv; v != nil; v = v.parent {
+		gen += atomic.LoadUint64(&v.gen)
+	}
+	return gen
+}
+
+// lookupRule returns the rule factory looking through the validator
+// layers from the child to the root and then through the builtin rules.
+func (mv *Validator) lookupRule(name string) (factory, bool) {
+	for v := mv; v != nil; v = v.parent {
+		if f, exists := v.config().rules[name]; exists {
+			return f, f != nil
+		}
+	}
+
+	if name == ruleRegexp {
+		return adaptFactory(mv.regexpRule), true
+	}
+
+	f, exists := builtinRules[name]
+	return f, exists
+}
+
+// lookupPattern returns the named regular expression looking
+// through the validator layers from the child to the root.
+func (mv *Validator) lookupPattern(name string) (*regexp.Regexp, bool) {
+	for v := mv; v != nil; v = v.parent {
+		if re, exists := v.config().patterns[name]; exists {
+			return re, true
+		}
+	}
+
+	return nil, false
+}
+
+// lookupLeaf reports whether the struct type is validated as a value
+// and returns the function converting its values, if any.
+func (mv *Validator) lookupLeaf(t reflect.Type) (LeafFunc, bool) {
+	for v := mv; v != nil; v = v.parent {
+		if f, exists := v.config().leaves[t]; exists {
+			return f, true
+		}
+	}
+
+	f, exists := builtinLeaves[t]
+	return f, exists
+}
+
+// lookupStructRules returns the rules registered for the field of
+// the struct type looking through the validator layers from the
+// child to the root.
+func (mv *Validator) lookupStructRules(st reflect.Type, field string) string {
+	for v := mv; v != nil; v = v.parent {
+		if rules, exists := v.config().structRules[st][field]; exists {
+			return rules
+		}
+	}
+
+	return ""
+}
+
+// lookupAlias returns the rules the alias stands for looking
+// through the validator layers from the child to the root. It
+// is safe to call on validators without a configuration.
+func (mv *Validator) lookupAlias(name string) (string, bool) {
+	if name == "" {
+		return "", false
+	}
+	for v := mv; v != nil; v = v.parent {
+		c, _ := v.cfg.Load().(*config)
+		if c == nil {
+			continue
+		}
+		if rules, exists := c.aliases[name]; exists {
+			return rules, true
+		}
+	}
+
+	return "", false
+}
+
+// lookupTypeRules returns the rules registered for the type looking
+// through the validator layers from the child to the root.
+func (mv *Validator) lookupTypeRules(t reflect.Type) string {
+	for v := mv; v != nil; v = v.parent {
+		if rules, exists := v.config().typeRules[t]; exists {
+			return rules
+		}
+	}
+
+	return ""
+}
+
+// lookupWrapper returns the function unwrapping the optional
+// values of the type looking through the validator layers from
+// the child to the root.
+func (mv *Validator) lookupWrapper(t reflect.Type) (UnwrapFunc, bool) {
+	for v := mv; v != nil; v = v.parent {
+		if f, exists := v.config().wrappers[t]; exists {
+			return f, true
+		}
+	}
+
+	return nil, false
+}
+
+// lookupTranslator returns the translator looking through the
+// validator layers from the child to the root, DefaultCatalog if
+// none is set.
+func (mv *Validator) lookupTranslator() Translator {
+	for v := mv; v != nil; v = v.parent {
+		if t := v.config().translator; t != nil {
+			return t
+		}
+	}
+
+	return DefaultCatalog
+}
+
+// isLeaf returns true for struct types which are validated
+// as values instead of being walked into: leaf types and
+// optional values
+func (mv *Validator) isLeaf(t reflect.Type) bool {
+	if _, ok := mv.lookupLeaf(t); ok {
+		return true
+	}
+	if _, ok := mv.lookupWrapper(t); ok {
+		return true
+	}
+	return isNullable(t)
+}
+
+// owner returns the nearest validator in the chain which overrides
+// anything. Validators with the same owner compile identical plans,
+// so they share the plan cache of the owner.
+func (mv *Validator) owner() *Validator {
+	v := mv
+	for v.parent != nil && v.config().isEmpty() {
+		v = v.parent
+	}
+	return v
+}
@@ -1,20 +1,43 @@
 package validator
 
 import (
+	"database/sql/driver"
+	"errors"
+	"math/big"
+	"net"
+	"net/url"
 	"reflect"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
 var (
 	regexpBase64 = regexp.MustCompile("^(?:[A-Za-z0-9+\\/]{4})*(?:[A-Za-z0-9+\\/]{2}==|[A-Za-z0-9+\\/]{3}=|[A-Za-z0-9+\\/]{4})$")
+	// errUnknownField is the cause of the ParamErr of a rule
+	// referring to a field which doesn't exist
+	errUnknownField = errors.New("unknown field")
 )
 
 // notempty tests whether a variable value non-zero
 // as defined by the golang spec.
 func notZero(v interface{}, param string) error {
+	zero, err := isZero(v)
+	if err != nil {
+		return err
+	}
+	if zero {
+		return ErrZeroValue
+	}
+	return nil
+}
+
+// isZero tests whether a variable value is zero as defined by
+// the golang spec. Valid sql.Null* and null.* values are zero
+// if the value they hold is zero.
+func isZero(v interface{}) (bool, error) {
 	st := reflect.ValueOf(v)
 	valid := true
 	switch st.Kind() {
@@ -33,52 +56,32 @@ func notZero(v interface{}, param string) error {
 	case reflect.Bool:
 		valid = st.Bool()
 	case reflect.Struct:
-		interfaceType := reflect.TypeOf(v)
-		if strings.Contains(strings.ToLower(interfaceType.String()), `null`) {
-			if _, exists := interfaceType.FieldByName(`Valid`); exists {
-				v := reflect.ValueOf(v)
-				if v.FieldByName(`Valid`).Bool() {
-					switch interfaceType.String() {
-					case `sql.NullInt64`, `null.Int`:
-						if _, exists = interfaceType.FieldByName(`Int64`); exists {
-							valid = v.FieldByName(`Int64`).Int() != 0
-						} else {
-							valid = false
-						}
-					case `sql.NullString`, `null.String`:
-						if _, exists = interfaceType.FieldByName(`String`); exists {
-							valid = v.FieldByName(`String`).String() != ``
-						} else {
-							valid = false
-						}
-					case `sql.NullFloat64`, `null.Float`:
-						if _, exists = interfaceType.FieldByName(`Float64`); exists {
-							valid = v.FieldByName(`Float64`).Float() != 0
-						} else {
-							valid = false
-						}
-					default:
-						return ErrUnsupported
-					}
-				} else {
-					valid = false
-				}
-			} else {
-				valid = false
+		switch x := v.(type) {
+		case time.Time:
+			return x.IsZero(), nil
+		case big.Int:
+			return x.Sign() == 0, nil
+		case big.Float:
+			return x.Sign() == 0, nil
+		}
+
+		// nullable values are unwrapped before the rules run,
+		// but the function may be called directly
+		if isNullable(st.Type()) {
+			u := nullableValue(st)
+			if !u.IsValid() {
+				return true, nil
 			}
-		} else {
-			return ErrUnsupported
+			return isZero(u.Interface())
 		}
+		return false, ErrUnsupported
 	case reflect.Invalid:
 		valid = false
 	default:
-		return ErrUnsupported
+		return false, ErrUnsupported
 	}
 
-	if !valid {
-		return ErrZeroValue
-	}
-	return nil
+	return !valid, nil
 }
 
 func notEmpty(v interface{}, param string) error {
@@ -112,228 +115,838 @@ func notEmpty(v interface{}, param string) error {
 	return nil
 }
 
-// length tests whether a variable's length is equal to a given
-// value. For strings it tests the number of characters whereas
-// for maps and slices it tests the number of items.
-func length(v interface{}, param string) error {
+// numParam is a numeric rule parameter parsed once for
+// every kind of value it may be compared with.
+type numParam struct {
+	i    int64
+	u    uint64
+	f    float64
+	b    *big.Float
+	t    time.Time
+	iErr error
+	uErr error
+	fErr error
+	bErr error
+	tErr error
+}
+
+// unordered is the result of comparing NaN values
+const unordered = 2
+
+// parseNumParam parses the parameter of a numeric rule. It fails
+// only if the parameter can't be used with any kind of value.
+func parseNumParam(param string) (numParam, error) {
+	var p numParam
+	p.i, p.iErr = asInt(param)
+	p.u, p.uErr = asUint(param)
+	p.f, p.fErr = asFloat(param)
+	p.b, p.bErr = asBigFloat(param)
+	p.t, p.tErr = time.Parse(time.RFC3339, param)
+	if p.iErr != nil && p.uErr != nil && p.fErr != nil && p.bErr != nil && p.tErr != nil {
+		return p, ErrBadParameter
+	}
+	return p, nil
+}
+
+// numParamFits reports ErrBadParameter if the parameter of a numeric
+// rule can't be compared with the values of the type
+func numParamFits(param string, t reflect.Type) error {
+	p, err := parseNumParam(param)
+	if err != nil {
+		return err
+	}
+
+	switch k := t.Kind(); {
+	case t == timeType:
+		err = p.tErr
+	case t == bigIntType || t == bigFloatType:
+		err = p.bErr
+	case hasLen(k) || isInt(k):
+		err = p.iErr
+	case isUint(k):
+		err = p.uErr
+	case k == reflect.Float32 || k == reflect.Float64:
+		err = p.fErr
+	}
+	if err != nil {
+		return ErrBadParameter
+	}
+	return nil
+}
+
+// compare compares the variable value with the parameter and
+// returns -1, 0, 1 or unordered. For strings it compares the number
+// of characters whereas for maps and slices the number of items.
+// Times are compared with the RFC3339 parameter.
+func (p numParam) compare(v interface{}) (int, error) {
 	st := reflect.ValueOf(v)
-	valid := true
 	switch st.Kind() {
 	case reflect.String:
-		p, err := asInt(param)
-		if err != nil {
-			return ErrBadParameter
+		if p.iErr != nil {
+			return 0, ErrBadParameter
 		}
-		valid = int64(len(st.String())) == p
+		return compareInt(int64(len(st.String())), p.i), nil
 	case reflect.Slice, reflect.Map, reflect.Array:
-		p, err := asInt(param)
-		if err != nil {
-			return ErrBadParameter
+		if p.iErr != nil {
+			return 0, ErrBadParameter
 		}
-		valid = int64(st.Len()) == p
+		return compareInt(int64(st.Len()), p.i), nil
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-		p, err := asInt(param)
-		if err != nil {
-			return ErrBadParameter
+		if p.iErr != nil {
+			return 0, ErrBadParameter
 		}
-		valid = st.Int() == p
+		return compareInt(st.Int(), p.i), nil
 	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
-		p, err := asUint(param)
-		if err != nil {
-			return ErrBadParameter
+		if p.uErr != nil {
+			return 0, ErrBadParameter
+		}
+		switch a := st.Uint(); {
+		case a < p.u:
+			return -1, nil
+		case a > p.u:
+			return 1, nil
 		}
-		valid = st.Uint() == p
+		return 0, nil
 	case reflect.Float32, reflect.Float64:
-		p, err := asFloat(param)
-		if err != nil {
-			return ErrBadParameter
+		if p.fErr != nil {
+			return 0, ErrBadParameter
+		}
+		switch a := st.Float(); {
+		case a < p.f:
+			return -1, nil
+		case a > p.f:
+			return 1, nil
+		case a == p.f:
+			return 0, nil
 		}
-		valid = st.Float() == p
+		return unordered, nil
+	case reflect.Struct:
+		switch a := v.(type) {
+		case time.Time:
+			if p.tErr != nil {
+				return 0, ErrBadParameter
+			}
+			return compareTime(a, p.t), nil
+		case big.Int:
+			if p.bErr != nil {
+				return 0, ErrBadParameter
+			}
+			return new(big.Float).SetInt(&a).Cmp(p.b), nil
+		case big.Float:
+			if p.bErr != nil {
+				return 0, ErrBadParameter
+			}
+			return a.Cmp(p.b), nil
+		}
+		return 0, ErrUnsupported
 	default:
-		return ErrUnsupported
+		return 0, ErrUnsupported
 	}
-	if !valid {
-		return ErrLen
+}
+
+// compareInt compares two int64 values
+func compareInt(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
 	}
-	return nil
+	return 0
 }
 
-// min tests whether a variable value is larger or equal to a given
-// number. For number types, it's a simple lesser-than test; for
-// strings it tests the number of characters whereas for maps
-// and slices it tests the number of items.
-func min(v interface{}, param string) error {
-	st := reflect.ValueOf(v)
-	invalid := false
-	switch st.Kind() {
-	case reflect.String:
-		p, err := asInt(param)
+// lengthRule is the builtin rule that tests whether a variable's length
+// is equal to a given value. For strings it tests the number of characters
+// whereas for maps and slices it tests the number of items.
+func lengthRule(param string) (Checker, error) {
+	p, err := parseNumParam(param)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(v interface{}) error {
+		c, err := p.compare(v)
 		if err != nil {
-			return ErrBadParameter
+			return err
 		}
-		invalid = int64(len(st.String())) < p
-	case reflect.Slice, reflect.Map, reflect.Array:
-		p, err := asInt(param)
-		if err != nil {
-			return ErrBadParameter
+		if c != 0 {
+			return ErrLen
 		}
-		invalid = int64(st.Len()) < p
-	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-		p, err := asInt(param)
+		return nil
+	}, nil
+}
+
+// minRule is the builtin rule that tests whether a variable value is
+// larger or equal to a given number. For number types, it's a simple
+// lesser-than test; for strings it tests the number of characters whereas
+// for maps and slices it tests the number of items.
+func minRule(param string) (Checker, error) {
+	p, err := parseNumParam(param)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(v interface{}) error {
+		c, err := p.compare(v)
 		if err != nil {
-			return ErrBadParameter
+			return err
 		}
-		invalid = st.Int() < p
-	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
-		p, err := asUint(param)
-		if err != nil {
-			return ErrBadParameter
+		if c == -1 {
+			return ErrMin
 		}
-		invalid = st.Uint() < p
-	case reflect.Float32, reflect.Float64:
-		p, err := asFloat(param)
+		return nil
+	}, nil
+}
+
+// maxRule is the builtin rule that tests whether a variable value is
+// lesser than a given value. For numbers, it's a simple lesser-than test;
+// for strings it tests the number of characters whereas for maps
+// and slices it tests the number of items.
+func maxRule(param string) (Checker, error) {
+	p, err := parseNumParam(param)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(v interface{}) error {
+		c, err := p.compare(v)
 		if err != nil {
-			return ErrBadParameter
+			return err
 		}
-		invalid = st.Float() < p
-	default:
+		if c == 1 {
+			return ErrMax
+		}
+		return nil
+	}, nil
+}
+
+// matchRegexp checks whether the string variable matches
+// the compiled regular expression
+func matchRegexp(v interface{}, re *regexp.Regexp) error {
+	st := reflect.ValueOf(v)
+	if st.Kind() != reflect.String {
 		return ErrUnsupported
 	}
-	if invalid {
-		return ErrMin
+
+	if !re.MatchString(st.String()) {
+		return ErrRegexp
 	}
 	return nil
 }
 
-// max tests whether a variable value is lesser than a given
-// value. For numbers, it's a simple lesser-than test; for
-// strings it tests the number of characters whereas for maps
-// and slices it tests the number of items.
-func max(v interface{}, param string) error {
+// regexpCache memoizes regular expressions compiled from
+// rule parameters. It is safe for concurrent use.
+type regexpCache struct {
+	mu       sync.RWMutex
+	compiled map[string]compiledRegexp
+}
+
+// compiledRegexp is a compiled regular expression or the
+// error of its compilation
+type compiledRegexp struct {
+	re  *regexp.Regexp
+	err error
+}
+
+// newRegexpCache creates an empty regexp cache
+func newRegexpCache() *regexpCache {
+	return &regexpCache{
+		compiled: make(map[string]compiledRegexp),
+	}
+}
+
+// get returns a compiled regular expression for the rule parameter.
+// Invalid expressions are memoized as well and reported as ParamErr.
+func (c *regexpCache) get(param string) (*regexp.Regexp, error) {
+	c.mu.RLock()
+	cr, exists := c.compiled[param]
+	c.mu.RUnlock()
+	if exists {
+		return cr.re, cr.err
+	}
+
+	re, err := regexp.Compile(param)
+	cr = compiledRegexp{re: re}
+	if err != nil {
+		cr.err = ParamErr{Param: param, Err: err}
+	}
+
+	c.mu.Lock()
+	c.compiled[param] = cr
+	c.mu.Unlock()
+	return cr.re, cr.err
+}
+
+// compareParam is a parameter of the compare rules parsed
+// once for every kind of value it may be compared with.
+type compareParam struct {
+	s    string
+	b    bool
+	bErr error
+	n    numParam
+	t    time.Time
+	tErr error
+}
+
+// compareRule is the builtin rule that checks whether the value is equal
+// (or, if negate is set, not equal) to the parameter converted according
+// to the kind of the value.
+// Works with: bool, int, uint, float, string, time.Time (RFC 3339)
+func compareRule(negate bool) RuleFactory {
+	return func(param string) (Checker, error) {
+		p := compareParam{s: param}
+		p.b, p.bErr = strconv.ParseBool(param)
+		p.n, _ = parseNumParam(param)
+		p.t, p.tErr = time.Parse(time.RFC3339, param)
+
+		return func(v interface{}) error {
+			equal, err := p.equals(v)
+			switch {
+			case err != nil:
+				return err
+			case !equal && !negate:
+				return ErrCompare
+			case equal && negate:
+				return ErrNCompare
+			}
+			return nil
+		}, nil
+	}
+}
+
+// equals compares the value with the parameter
+func (p compareParam) equals(v interface{}) (bool, error) {
 	st := reflect.ValueOf(v)
-	var invalid bool
-	switch st.Kind() {
-	case reflect.String:
-		p, err := asInt(param)
-		if err != nil {
-			return ErrBadParameter
+	switch {
+	case !st.IsValid():
+		return false, nil
+	case st.Kind() == reflect.Bool:
+		if p.bErr != nil {
+			return false, ErrBadParameter
 		}
-		invalid = int64(len(st.String())) > p
-	case reflect.Slice, reflect.Map, reflect.Array:
-		p, err := asInt(param)
-		if err != nil {
-			return ErrBadParameter
+		return st.Bool() == p.b, nil
+	case st.Kind() == reflect.String:
+		return st.String() == p.s, nil
+	case isNumber(st.Kind()):
+		c, err := p.n.compare(v)
+		return c == 0, err
+	case st.Type() == timeType:
+		if p.tErr != nil {
+			return false, ErrBadParameter
+		}
+		return st.Interface().(time.Time).Equal(p.t), nil
+	}
+	return false, ErrUnsupported
+}
+
+// inParam is a list of values of the in rule parsed once
+// for every kind of value it may be compared with.
+type inParam struct {
+	// placeholders holds {allowed} for custom error messages
+	placeholders map[string]string
+	strings      []string
+	ints         []int64
+	uints        []uint64
+	floats       []float64
+	iErr         error
+	uErr         error
+	fErr         error
+}
+
+// inRule is the builtin rule that checks whether the value is
+// listed in the list of supported values.
+// Works with: int, uint, float, string
+func inRule(param string) (Checker, error) {
+	return parseInParam(param).check, nil
+}
+
+// parseInParam parses the list of values of the in rule
+func parseInParam(param string) inParam {
+	p := inParam{strings: strings.Split(param, ",")}
+	p.placeholders = map[string]string{"allowed": strings.Join(p.strings, ", ")}
+	for _, s := range p.strings {
+		if vInt, err := asInt(s); err == nil {
+			p.ints = append(p.ints, vInt)
+		} else {
+			p.iErr = err
 		}
-		invalid = int64(st.Len()) > p
+		if vUint, err := asUint(s); err == nil {
+			p.uints = append(p.uints, vUint)
+		} else {
+			p.uErr = err
+		}
+		if vFloat, err := asFloat(s); err == nil {
+			p.floats = append(p.floats, vFloat)
+		} else {
+			p.fErr = err
+		}
+	}
+	return p
+}
+
+// inParamFits reports ErrBadParameter if the values listed in the
+// parameter of the in rule can't be compared with the values of the type
+func inParamFits(param string, t reflect.Type) error {
+	var (
+		p   = parseInParam(param)
+		err error
+	)
+	switch k := t.Kind(); {
+	case isInt(k):
+		err = p.iErr
+	case isUint(k):
+		err = p.uErr
+	case k == reflect.Float32 || k == reflect.Float64:
+		err = p.fErr
+	case k != reflect.String:
+		err = ErrBadParameter
+	}
+	if err != nil {
+		return ErrBadParameter
+	}
+	return nil
+}
+
+// check looks for the value in the list
+func (p inParam) check(v interface{}) error {
+	var (
+		st    = reflect.ValueOf(v)
+		found bool
+	)
+
+	switch st.Kind() {
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-		p, err := asInt(param)
-		if err != nil {
+		if p.iErr != nil {
 			return ErrBadParameter
 		}
-		invalid = st.Int() > p
+		for _, e := range p.ints {
+			if found = st.Int() == e; found {
+				break
+			}
+		}
 	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
-		p, err := asUint(param)
-		if err != nil {
+		if p.uErr != nil {
 			return ErrBadParameter
 		}
-		invalid = st.Uint() > p
+		for _, e := range p.uints {
+			if found = st.Uint() == e; found {
+				break
+			}
+		}
 	case reflect.Float32, reflect.Float64:
-		p, err := asFloat(param)
-		if err != nil {
+		if p.fErr != nil {
 			return ErrBadParameter
 		}
-		invalid = st.Float() > p
+		for _, e := range p.floats {
+			if found = st.Float() == e; found {
+				break
+			}
+		}
+	case reflect.String:
+		for _, e := range p.strings {
+			if found = st.String() == e; found {
+				break
+			}
+		}
 	default:
-		return ErrUnsupported
+		return ErrBadParameter
 	}
-	if invalid {
-		return ErrMax
+
+	if !found {
+		return PlaceholderErr{Err: ErrInvalidValue, Placeholders: p.placeholders}
 	}
+
 	return nil
 }
 
-// regex is the builtin validation function that checks
-// whether the string variable matches a regular expression
-func regex(v interface{}, param string) error {
-	s, ok := v.(string)
-	if !ok {
-		return ErrUnsupported
+// in is the validation function version of inRule
+func in(v interface{}, param string) error {
+	c, err := inRule(param)
+	if err != nil {
+		return err
 	}
 
-	re, err := regexp.Compile(param)
+	err, _ = splitPlaceholders(c(v))
+	return err
+}
+
+// bigIntType and bigFloatType are the types of the big numbers
+var (
+	bigIntType   = reflect.TypeOf(big.Int{})
+	bigFloatType = reflect.TypeOf(big.Float{})
+)
+
+// timeType is the type of time.Time values
+var timeType = reflect.TypeOf(time.Time{})
+
+// urlString is the LeafFunc of url.URL values
+func urlString(v interface{}) interface{} {
+	u := v.(url.URL)
+	return u.String()
+}
+
+// ipNetString is the LeafFunc of net.IPNet values. The zero
+// network is converted to an empty string.
+func ipNetString(v interface{}) interface{} {
+	n := v.(net.IPNet)
+	if n.IP == nil {
+		return ""
+	}
+	return n.String()
+}
+
+// valuerType is the type of the driver.Valuer interface
+var valuerType = reflect.TypeOf((*driver.Valuer)(nil)).Elem()
+
+// isNullable returns true for the struct types implementing
+// driver.Valuer with a Valid flag, such as sql.NullString
+func isNullable(t reflect.Type) bool {
+	if t.Kind() != reflect.Struct || !t.Implements(valuerType) {
+		return false
+	}
+	f, ok := t.FieldByName("Valid")
+	return ok && f.Type.Kind() == reflect.Bool
+}
+
+// nullableValue resolves the nullable value to the value it wraps.
+// The result is invalid if the value is absent.
+func nullableValue(v reflect.Value) reflect.Value {
+	if !v.IsValid() || !isNullable(v.Type()) {
+		return v
+	}
+	u, err := v.Interface().(driver.Valuer).Value()
 	if err != nil {
-		return ErrBadParameter
+		return v
 	}
+	return reflect.ValueOf(u)
+}
 
-	if !re.MatchString(s) {
-		return ErrRegexp
+// compareTime compares two time values
+func compareTime(a, b time.Time) int {
+	switch {
+	case a.Before(b):
+		return -1
+	case a.After(b):
+		return 1
 	}
-	return nil
+	return 0
 }
 
-// in is the builtin validation function that checks
-// whether the value is listed in the list of supported values.
-// Works with: int, uint, float, string
-func in(v interface{}, param string) error {
-	var (
-		st               = reflect.ValueOf(v)
-		params           = strings.Split(param, ",")
-		expectedValues   = make([]interface{}, 0)
-		actualValueTyped interface{}
-	)
+// fieldRule builds a cross-field rule which compares the value with
+// the sibling field named by the parameter. Dotted paths refer to the
+// fields of nested structs.
+func fieldRule(test func(c int) bool, fail error) factory {
+	return func(param string, parent, _ reflect.Type) (checkFunc, error) {
+		if param == "" {
+			return nil, ErrBadParameter
+		}
+		path, err := resolvePath(parent, param)
+		if err != nil {
+			return nil, err
+		}
 
-	switch st.Kind() {
-	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
-		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
-		for _, p := range params {
-			vInt, err := asInt(p)
+		return func(s scope, v interface{}) error {
+			if !s.parent.IsValid() {
+				return ErrUnsupported
+			}
+
+			other, exists := fieldByPath(s.parent, path)
+			if !exists {
+				return ParamErr{Param: param, Err: errUnknownField}
+			}
+
+			c, err := compareValues(reflect.ValueOf(v), other)
 			if err != nil {
-				return ErrBadParameter
+				return err
+			}
+			if !test(c) {
+				return fail
 			}
+			return nil
+		}, nil
+	}
+}
 
-			expectedValues = append(expectedValues, vInt)
+// conditionRule builds a conditional presence rule. The parameter is
+// a space separated list of sibling fields, or of field and value pairs
+// if pairs is set. The condition reports whether the rule applies
+// according to the siblings; then the value must be present or, if
+// excluded is set, it must be zero.
+func conditionRule(pairs, excluded bool, condition func(s scope, fields []fieldPath, values []string) bool) factory {
+	return func(param string, parent, _ reflect.Type) (checkFunc, error) {
+		params := strings.Fields(param)
+		if len(params) == 0 || (pairs && len(params)%2 != 0) {
+			return nil, ErrBadParameter
 		}
 
-		actualValueTyped = st.Int()
-	case reflect.Float32, reflect.Float64:
-		for _, p := range params {
-			vFloat, err := asFloat(p)
+		var (
+			fields []fieldPath
+			values []string
+		)
+		for i := 0; i < len(params); i++ {
+			path, err := resolvePath(parent, params[i])
 			if err != nil {
-				return ErrBadParameter
+				return nil, err
 			}
+			fields = append(fields, path)
+			if pairs {
+				i++
+				values = append(values, params[i])
+			}
+		}
 
-			expectedValues = append(expectedValues, vFloat)
+		return func(s scope, v interface{}) error {
+			if !s.parent.IsValid() {
+				return ErrUnsupported
+			}
+			if !condition(s, fields, values) {
+				return nil
+			}
+
+			zero, err := isZero(v)
+			if err != nil {
+				return err
+			}
+			switch {
+			case excluded && !zero:
+				return ErrExcluded
+			case !excluded && zero:
+				return ErrZeroValue
+			}
+			return nil
+		}, nil
+	}
+}
+
+// fieldsEqual is the condition of required_if and excluded_if rules:
+// all the fields are equal to the respective values.
+func fieldsEqual(s scope, fields []fieldPath, values []string) bool {
+	for i, path := range fields {
+		f, exists := fieldByPath(s.parent, path)
+		if !exists || !valueEquals(f, values[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// anyFieldPresent is the condition of the required_with rule
+func anyFieldPresent(s scope, fields []fieldPath, _ []string) bool {
+	for _, path := range fields {
+		if f, exists := fieldByPath(s.parent, path); exists && isPresent(f) {
+			return true
 		}
+	}
+	return false
+}
 
-		actualValueTyped = st.Float()
-	case reflect.String:
-		for _, p := range params {
-			expectedValues = append(expectedValues, p)
+// anyFieldAbsent is the condition of the required_without rule
+func anyFieldAbsent(s scope, fields []fieldPath, _ []string) bool {
+	for _, path := range fields {
+		if f, exists := fieldByPath(s.parent, path); !exists || !isPresent(f) {
+			return true
 		}
+	}
+	return false
+}
 
-		actualValueTyped = st.String()
-	default:
-		return ErrBadParameter
+// isPresent returns true if the field value is not zero. Values
+// of unsupported types are always present.
+func isPresent(f reflect.Value) bool {
+	if !f.IsValid() || (f.Kind() == reflect.Ptr && f.IsNil()) {
+		return false
 	}
 
+	zero, err := isZero(f.Interface())
+	return err != nil || !zero
+}
+
+// valueEquals checks whether the field value is equal to
+// the parameter converted to the type of the field
+func valueEquals(f reflect.Value, param string) bool {
+	switch {
+	case f.Kind() == reflect.String:
+		return f.String() == param
+	case f.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(param)
+		return err == nil && f.Bool() == b
+	case isInt(f.Kind()):
+		i, err := asInt(param)
+		return err == nil && f.Int() == i
+	case isUint(f.Kind()):
+		u, err := asUint(param)
+		return err == nil && f.Uint() == u
+	case f.Kind() == reflect.Float32 || f.Kind() == reflect.Float64:
+		fl, err := asFloat(param)
+		return err == nil && f.Float() == fl
+	}
+	return false
+}
+
+// fieldPath is the path of a field named by the parameter of a
+// cross-field rule, e.g. Address.City
+type fieldPath struct {
+	names []string
+	// index holds the field index sequences of the names resolved
+	// when the rule is built, nil for the fields of the values of
+	// interface types resolved at the validation
+	index [][]int
+}
+
+// resolvePath checks that the dotted path of the parameter leads to
+// a field of the parent struct type, if it's known, and resolves the
+// field indexes. Fields of interface types are resolved at the
+// validation.
+func resolvePath(parent reflect.Type, param string) (fieldPath, error) {
 	var (
-		expectedValuesR = reflect.ValueOf(expectedValues)
-		found           bool
+		names = strings.Split(param, ".")
+		p     = fieldPath{names: names, index: make([][]int, len(names))}
+		t     = parent
 	)
-
-	for j := 0; j < expectedValuesR.Len(); j++ {
-		matchElem := expectedValuesR.Index(j).Interface()
-		if reflect.DeepEqual(reflect.ValueOf(actualValueTyped).Interface(), matchElem) {
-			found = true
+	for i, name := range names {
+		if t == nil {
+			break
+		}
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		if t.Kind() == reflect.Interface {
 			break
 		}
+		if t.Kind() != reflect.Struct {
+			return p, ParamErr{Param: param, Err: errUnknownField}
+		}
+
+		sf, ok := t.FieldByName(name)
+		if !ok || sf.PkgPath != "" {
+			return p, ParamErr{Param: param, Err: errUnknownField}
+		}
+		p.index[i] = sf.Index
+		t = sf.Type
 	}
+	return p, nil
+}
 
-	if !found {
-		return ErrInvalidValue
+// fieldByPath returns the value of the field following the path
+// through nested structs and pointers to them. It reports false if
+// the field is missing, e.g. promoted through a nil embedded pointer.
+func fieldByPath(v reflect.Value, path fieldPath) (reflect.Value, bool) {
+	for i, name := range path.names {
+		for (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && !v.IsNil() {
+			v = v.Elem()
+		}
+		if v.Kind() != reflect.Struct {
+			return reflect.Value{}, false
+		}
+
+		index := path.index[i]
+		if index == nil {
+			sf, ok := v.Type().FieldByName(name)
+			if !ok {
+				return reflect.Value{}, false
+			}
+			index = sf.Index
+		}
+
+		var ok bool
+		if v, ok = fieldByIndex(v, index); !ok || !v.CanInterface() {
+			return reflect.Value{}, false
+		}
 	}
 
-	return nil
+	for v.Kind() == reflect.Ptr && !v.IsNil() {
+		v = v.Elem()
+	}
+	return nullableValue(v), true
+}
+
+// compareValues compares two values and returns -1, 0, 1 or unordered.
+// Numbers are compared by value, time.Time chronologically, strings
+// and bools by value, slices, arrays and maps by the number of items.
+func compareValues(a, b reflect.Value) (int, error) {
+	if a.Kind() == reflect.Ptr && a.IsNil() {
+		a = reflect.Value{}
+	}
+	if b.Kind() == reflect.Ptr && b.IsNil() {
+		b = reflect.Value{}
+	}
+	if !a.IsValid() || !b.IsValid() {
+		if !a.IsValid() && !b.IsValid() {
+			return 0, nil
+		}
+		return unordered, nil
+	}
+
+	switch {
+	case a.Type() == timeType && b.Type() == timeType:
+		return compareTime(a.Interface().(time.Time), b.Interface().(time.Time)), nil
+	case isInt(a.Kind()) && isInt(b.Kind()):
+		return compareInt(a.Int(), b.Int()), nil
+	case isUint(a.Kind()) && isUint(b.Kind()):
+		switch ua, ub := a.Uint(), b.Uint(); {
+		case ua < ub:
+			return -1, nil
+		case ua > ub:
+			return 1, nil
+		}
+		return 0, nil
+	case isNumber(a.Kind()) && isNumber(b.Kind()):
+		switch fa, fb := asFloatValue(a), asFloatValue(b); {
+		case fa < fb:
+			return -1, nil
+		case fa > fb:
+			return 1, nil
+		case fa == fb:
+			return 0, nil
+		}
+		return unordered, nil
+	case a.Kind() == reflect.String && b.Kind() == reflect.String:
+		return strings.Compare(a.String(), b.String()), nil
+	case a.Kind() == reflect.Bool && b.Kind() == reflect.Bool:
+		if a.Bool() == b.Bool() {
+			return 0, nil
+		}
+		return unordered, nil
+	case hasLen(a.Kind()) && hasLen(b.Kind()):
+		return compareInt(int64(a.Len()), int64(b.Len())), nil
+	}
+
+	return 0, ErrUnsupported
+}
+
+// isInt returns true for signed integer kinds
+func isInt(k reflect.Kind) bool {
+	return k >= reflect.Int && k <= reflect.Int64
+}
+
+// isUint returns true for unsigned integer kinds
+func isUint(k reflect.Kind) bool {
+	return k >= reflect.Uint && k <= reflect.Uintptr
+}
+
+// isNumber returns true for integer and float kinds
+func isNumber(k reflect.Kind) bool {
+	return isInt(k) || isUint(k) || k == reflect.Float32 || k == reflect.Float64
+}
+
+// hasLen returns true for kinds having length
+func hasLen(k reflect.Kind) bool {
+	switch k {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		return true
+	}
+	return false
+}
+
+// asFloatValue converts a number value to float64
+func asFloatValue(v reflect.Value) float64 {
+	switch {
+	case isInt(v.Kind()):
+		return float64(v.Int())
+	case isUint(v.Kind()):
+		return float64(v.Uint())
+	}
+	return v.Float()
 }
 
 // typeValid is the builtin validation function that checks
@@ -388,3 +1001,16 @@ func asFloat(param string) (float64, error) {
 	}
 	return i, nil
 }
+
+// asBigFloat returns the parameter as a big.Float
+// or an error if it is not a number
+func asBigFloat(param string) (*big.Float, error) {
+	f, ok := new(big.Float).SetPrec(bigPrec).SetString(param)
+	if !ok {
+		return nil, ErrBadParameter
+	}
+	return f, nil
+}
+
+// bigPrec is the precision of big.Float rule parameters
+const bigPrec = 256
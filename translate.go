@@ -0,0 +1,507 @@
+package validator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Translator provides the messages of the errors in other languages.
+type Translator interface {
+	// Translate returns the message of the key in the locale with
+	// the placeholders replaced by args, or false if there is none.
+	Translate(locale, key string, args map[string]string) (string, bool)
+}
+
+// Plural forms of the messages, the forms used by a language are
+// chosen by its plural rule. A message without the form of the
+// count falls back to PluralOther.
+const (
+	PluralOne   = "one"
+	PluralFew   = "few"
+	PluralMany  = "many"
+	PluralOther = "other"
+)
+
+// Catalog is an in-memory Translator holding the messages indexed
+// by the locale and the key. It is safe for concurrent use.
+type Catalog struct {
+	mu       sync.RWMutex
+	messages map[string]map[string]map[string]string
+}
+
+// NewCatalog creates an empty catalog
+func NewCatalog() *Catalog {
+	return &Catalog{messages: make(map[string]map[string]map[string]string)}
+}
+
+// Add sets the message of the key in the locale
+func (c *Catalog) Add(locale, key, message string) {
+	c.AddPlural(locale, key, map[string]string{PluralOther: message})
+}
+
+// AddPlural sets the plural forms of the message of the key in
+// the locale, e.g. {"one": "{count} item", "other": "{count} items"}.
+// The form is chosen by the count placeholder.
+func (c *Catalog) AddPlural(locale, key string, forms map[string]string) {
+	locale = normalizeLocale(locale)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.messages[locale] == nil {
+		c.messages[locale] = make(map[string]map[string]string)
+	}
+	c.messages[locale][key] = forms
+}
+
+// LoadJSON adds the messages read from r. The messages are objects
+// of keys indexed by the locale, the value of a key is either the
+// message or an object of its plural forms:
+//
+//	{"en": {"min": "must be at least {param}",
+//	        "items.min": {"one": "needs {count} item", "other": "needs {count} items"}}}
+func (c *Catalog) LoadJSON(r io.Reader) error {
+	var locales map[string]map[string]json.RawMessage
+	if err := json.NewDecoder(r).Decode(&locales); err != nil {
+		return err
+	}
+
+	for locale, messages := range locales {
+		for key, raw := range messages {
+			var message string
+			if err := json.Unmarshal(raw, &message); err == nil {
+				c.Add(locale, key, message)
+				continue
+			}
+			var forms map[string]string
+			if err := json.Unmarshal(raw, &forms); err != nil {
+				return fmt.Errorf("message %q of %q: %w", key, locale, err)
+			}
+			c.AddPlural(locale, key, forms)
+		}
+	}
+	return nil
+}
+
+// LoadFile adds the messages of the JSON file, see LoadJSON.
+func (c *Catalog) LoadFile(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return c.LoadJSON(f)
+}
+
+// Translate returns the message of the key in the locale or in its
+// language, e.g. ru for ru-RU. The plural form is chosen by the
+// count argument.
+func (c *Catalog) Translate(locale, key string, args map[string]string) (string, bool) {
+	locale = normalizeLocale(locale)
+
+	c.mu.RLock()
+	forms, exists := c.messages[locale][key]
+	if !exists {
+		forms, exists = c.messages[language(locale)][key]
+	}
+	c.mu.RUnlock()
+	if !exists {
+		return "", false
+	}
+
+	message, exists := forms[PluralOther]
+	if count, err := strconv.ParseFloat(args["count"], 64); err == nil {
+		if m, ok := forms[pluralForm(language(locale), count)]; ok {
+			message, exists = m, true
+		}
+	}
+	if !exists {
+		return "", false
+	}
+
+	return expand(message, func(name string) (string, bool) {
+		value, ok := args[name]
+		return value, ok
+	}), true
+}
+
+// normalizeLocale converts the locale to the form of the catalog
+// keys, e.g. ru_RU to ru-ru
+func normalizeLocale(locale string) string {
+	return strings.ToLower(strings.Replace(locale, "_", "-", -1))
+}
+
+// language returns the language of the locale, e.g. ru for ru-ru
+func language(locale string) string {
+	if i := strings.IndexByte(locale, '-'); i >= 0 {
+		return locale[:i]
+	}
+	return locale
+}
+
+// pluralForm returns the plural form of the count in the language.
+// Languages without a rule use the English one.
+func pluralForm(lang string, count float64) string {
+	if count != math.Trunc(count) {
+		return PluralOther
+	}
+
+	n := int64(math.Abs(count))
+	switch lang {
+	case "ru", "uk", "be":
+		switch {
+		case n%10 == 1 && n%100 != 11:
+			return PluralOne
+		case n%10 >= 2 && n%10 <= 4 && (n%100 < 12 || n%100 > 14):
+			return PluralFew
+		default:
+			return PluralMany
+		}
+	default:
+		if n == 1 {
+			return PluralOne
+		}
+		return PluralOther
+	}
+}
+
+// DefaultCatalog holds the English and Russian messages of the builtin
+// rules and errors. It is the translator of the validators unless it's
+// changed with SetTranslator, other messages can be added to it.
+var DefaultCatalog = newDefaultCatalog()
+
+// defaultMessages are the messages of DefaultCatalog. The rule keys are
+// used for the errors with the rule details (the Detailed mode and
+// ValidateCtx), the error.<code> keys for the rest.
+var defaultMessages = map[string]map[string]string{
+	"en": {
+		"notempty":         "must not be empty",
+		"empty":            "must not be empty",
+		"len":              "must have a length of {param}",
+		"min":              "must be at least {param}",
+		"max":              "must be at most {param}",
+		"in":               "must be one of {allowed}",
+		"type":             "must be a valid {param}",
+		"compare":          "must be equal to {param}",
+		"ncompare":         "must not be equal to {param}",
+		"eqfield":          "must be equal to {param}",
+		"nefield":          "must not be equal to {param}",
+		"gtfield":          "must be greater than {param}",
+		"ltfield":          "must be less than {param}",
+		"regexp":           "has an invalid format",
+		"required_if":      "is required",
+		"required_with":    "is required",
+		"required_without": "is required",
+		"excluded_if":      "must be empty",
+
+		"error.zero_value":          "must not be empty",
+		"error.min":                 "is too small",
+		"error.max":                 "is too large",
+		"error.len":                 "has an invalid length",
+		"error.regexp":              "has an invalid format",
+		"error.unsupported":         "has an unsupported type",
+		"error.bad_parameter":       "has a rule with a bad parameter",
+		"error.unknown_tag":         "has an unknown rule",
+		"error.invalid":             "is invalid",
+		"error.invalid_value":       "has an invalid value",
+		"error.invalid_typed_value": "has an invalid value for its type",
+		"error.eqfield":             "must be equal to the other field",
+		"error.nefield":             "must not be equal to the other field",
+		"error.gtfield":             "must be greater than the other field",
+		"error.ltfield":             "must be less than the other field",
+		"error.excluded":            "must be empty",
+		"error.compare":             "has an unexpected value",
+		"error.ncompare":            "has a forbidden value",
+		"error.alias_cycle":         "has an alias referring to itself",
+		"error.canceled":            "validation was canceled",
+		"error.deadline_exceeded":   "validation timed out",
+	},
+	"ru": {
+		"notempty":         "не должно быть пустым",
+		"empty":            "не должно быть пустым",
+		"len":              "должно иметь длину {param}",
+		"min":              "должно быть не меньше {param}",
+		"max":              "должно быть не больше {param}",
+		"in":               "должно быть одним из: {allowed}",
+		"type":             "должно быть корректным значением типа {param}",
+		"compare":          "должно быть равно {param}",
+		"ncompare":         "не должно быть равно {param}",
+		"eqfield":          "должно совпадать с {param}",
+		"nefield":          "не должно совпадать с {param}",
+		"gtfield":          "должно быть больше {param}",
+		"ltfield":          "должно быть меньше {param}",
+		"regexp":           "имеет неверный формат",
+		"required_if":      "обязательно для заполнения",
+		"required_with":    "обязательно для заполнения",
+		"required_without": "обязательно для заполнения",
+		"excluded_if":      "должно быть пустым",
+
+		"error.zero_value":          "не должно быть пустым",
+		"error.min":                 "слишком мало",
+		"error.max":                 "слишком велико",
+		"error.len":                 "имеет неверную длину",
+		"error.regexp":              "имеет неверный формат",
+		"error.unsupported":         "имеет неподдерживаемый тип",
+		"error.bad_parameter":       "содержит правило с неверным параметром",
+		"error.unknown_tag":         "содержит неизвестное правило",
+		"error.invalid":             "некорректно",
+		"error.invalid_value":       "имеет недопустимое значение",
+		"error.invalid_typed_value": "имеет недопустимое для своего типа значение",
+		"error.eqfield":             "должно совпадать с другим полем",
+		"error.nefield":             "не должно совпадать с другим полем",
+		"error.gtfield":             "должно быть больше другого поля",
+		"error.ltfield":             "должно быть меньше другого поля",
+		"error.excluded":            "должно быть пустым",
+		"error.compare":             "имеет неожиданное значение",
+		"error.ncompare":            "имеет запрещённое значение",
+		"error.alias_cycle":         "содержит псевдоним, ссылающийся на себя",
+		"error.canceled":            "проверка отменена",
+		"error.deadline_exceeded":   "время проверки истекло",
+	},
+}
+
+// newDefaultCatalog creates a catalog of defaultMessages
+func newDefaultCatalog() *Catalog {
+	c := NewCatalog()
+	for locale, messages := range defaultMessages {
+		for key, message := range messages {
+			c.Add(locale, key, message)
+		}
+	}
+	return c
+}
+
+// LocalizedErr is an error with a translated message
+type LocalizedErr struct {
+	Err     error
+	Message string
+}
+
+// Error returns the translated message
+func (e *LocalizedErr) Error() string {
+	return e.Message
+}
+
+// Unwrap returns the original error
+func (e *LocalizedErr) Unwrap() error {
+	return e.Err
+}
+
+// localeKey is the context key of the locale
+type localeKey struct{}
+
+// WithLocale returns a copy of ctx with the locale. ValidateCtx
+// translates the errors to the locale of its context.
+func WithLocale(ctx context.Context, locale string) context.Context {
+	return context.WithValue(ctx, localeKey{}, locale)
+}
+
+// localeFrom returns the locale of ctx, if any
+func localeFrom(ctx context.Context) (string, bool) {
+	locale, ok := ctx.Value(localeKey{}).(string)
+	return locale, ok && locale != ""
+}
+
+// SetTranslator sets the translator of the error messages
+func SetTranslator(t Translator) {
+	defaultValidator.SetTranslator(t)
+}
+
+// SetTranslator sets the translator of the error messages
+func (mv *Validator) SetTranslator(t Translator) {
+	mv.update(func(c *config) {
+		c.translator = t
+	})
+}
+
+// Translate returns a copy of the errors with the messages translated
+// to the locale. The message of an error is looked up by the keys:
+//
+//   - the custom message (msg_<rule>=errors.form.too_small),
+//   - the field and the rule, e.g. items.qty.min,
+//   - the rule, e.g. min,
+//   - the error code, e.g. error.min,
+//
+// the error keeps its message if none of them is found. Messages have
+// the param, count, field, path, rule and value placeholders and the
+// ones of the rule, count is the param if it is a number. The rule
+// details are known in the Detailed mode, in the other modes the rule
+// of an error is found by its code, e.g. min for ErrMin, so the field
+// keys still work, but the rule keys are skipped as the parameter is
+// unknown. ValidateCtx has the rule details in every mode. The
+// translated errors still match their rule errors with errors.Is.
+func Translate(errs ErrorMap, locale string) ErrorMap {
+	return defaultValidator.Translate(errs, locale)
+}
+
+// Translate returns a copy of the errors with the messages translated
+// to the locale, see the Translate function.
+func (mv *Validator) Translate(errs ErrorMap, locale string) ErrorMap {
+	return mv.translate(errs, locale, Detailed)
+}
+
+// translate returns a copy of the errors with the messages translated
+// to the locale. The FieldError values are reported in the mode, so
+// the errors validated in the Detailed mode for the sake of their
+// details are reported as if they were validated in the mode.
+func (mv *Validator) translate(errs ErrorMap, locale string, mode Mode) ErrorMap {
+	t := mv.lookupTranslator()
+	m := make(ErrorMap, len(errs))
+	for field, err := range errs {
+		if arr, ok := err.(ErrorArray); ok {
+			translated := make(ErrorArray, len(arr))
+			for i, e := range arr {
+				translated[i] = translateError(t, locale, field, e, mode)
+			}
+			m[field] = translated
+			continue
+		}
+		m[field] = translateError(t, locale, field, err, mode)
+	}
+	return m
+}
+
+// codeRules holds the rules reporting the errors of the codes. The
+// rule of an error without details is found by its code, the field
+// keys of all the rules reporting the code are looked up in order.
+var codeRules = map[string][]string{
+	"zero_value":          {"notempty", "empty", "required_if", "required_with", "required_without"},
+	"min":                 {"min"},
+	"max":                 {"max"},
+	"len":                 {"len"},
+	"regexp":              {"regexp"},
+	"invalid_value":       {"in"},
+	"invalid_typed_value": {"type"},
+	"eqfield":             {"eqfield"},
+	"nefield":             {"nefield"},
+	"gtfield":             {"gtfield"},
+	"ltfield":             {"ltfield"},
+	"excluded":            {"excluded_if"},
+	"compare":             {"compare"},
+	"ncompare":            {"ncompare"},
+}
+
+// translateError returns the error of the field with the message
+// translated to the locale, a FieldError is reported in the mode
+func translateError(t Translator, locale, field string, err error, mode Mode) error {
+	message, ok := translateMessage(t, locale, field, err)
+
+	if fe, isField := err.(*FieldError); isField && mode&Detailed == 0 {
+		err = plainError(fe.Rule, fe.Err, fe.Message, fe.Message != "", mode)
+	}
+	if !ok {
+		return err
+	}
+
+	switch e := err.(type) {
+	case *FieldError:
+		translated := *e
+		translated.Message = message
+		return &translated
+	case RuleErr:
+		return RuleErr{Rule: e.Rule, Err: &LocalizedErr{Err: e.Err, Message: message}}
+	default:
+		return &LocalizedErr{Err: err, Message: message}
+	}
+}
+
+// translateMessage looks up the message of the error of the field
+// in the locale, it reports false if there is none
+func translateMessage(t Translator, locale, field string, err error) (string, bool) {
+	var (
+		args   = map[string]string{"path": field, "field": fieldName(field)}
+		custom string
+		rules  []string
+		code   string
+		param  bool
+	)
+	switch e := err.(type) {
+	case *FieldError:
+		for k, v := range e.Placeholders {
+			args[k] = v
+		}
+		if e.Attr != "" {
+			args["field"] = e.Attr
+		}
+		args["rule"] = e.Rule
+		args["param"] = e.Param
+		args[e.Rule] = e.Param
+		if e.Value != nil {
+			args["value"] = fmt.Sprint(e.Value)
+		}
+		if _, exists := args["count"]; !exists {
+			if _, err := strconv.ParseFloat(e.Param, 64); err == nil {
+				args["count"] = e.Param
+			}
+		}
+		custom, rules, code, param = e.Message, []string{e.Rule}, codeOf(e.Err), true
+	case RuleErr:
+		args["rule"] = e.Rule
+		rules, code = []string{e.Rule}, codeOf(e.Err)
+		if code == "" {
+			custom = e.Err.Error()
+		}
+	default:
+		code = codeOf(err)
+		if code == "" {
+			custom = err.Error()
+		}
+		rules = codeRules[code]
+	}
+
+	keys := make([]string, 0, len(rules)+3)
+	if custom != "" {
+		keys = append(keys, custom)
+	}
+	if args["path"] != "" {
+		for _, rule := range rules {
+			keys = append(keys, fieldKey(field)+"."+rule)
+		}
+	}
+	// rule messages may refer to the parameter
+	if param {
+		keys = append(keys, rules...)
+	}
+	if code != "" {
+		keys = append(keys, "error."+code)
+	}
+
+	for _, key := range keys {
+		if message, ok := t.Translate(locale, key, args); ok {
+			return message, true
+		}
+	}
+	return "", false
+}
+
+// fieldKey returns the path of the field without the indexes and map
+// keys, e.g. items.qty for items[2].qty
+func fieldKey(path string) string {
+	var (
+		b     strings.Builder
+		depth int
+	)
+	for _, r := range path {
+		switch {
+		case r == '[':
+			depth++
+		case r == ']':
+			depth--
+		case depth == 0:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// fieldName returns the last name of the path, e.g. qty for items[2].qty
+func fieldName(path string) string {
+	key := fieldKey(path)
+	return key[strings.LastIndexByte(key, '.')+1:]
+}
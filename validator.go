@@ -1,12 +1,17 @@
 package validator
 
 import (
+	"context"
+	"database/sql/driver"
 	"errors"
 	"fmt"
 	"reflect"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
-	"unicode"
+	"sync"
+	"sync/atomic"
 )
 
 // TextErr is an error that also implements the TextMarshaller interface for
@@ -27,6 +32,11 @@ func (t TextErr) MarshalText() ([]byte, error) {
 	return []byte(t.Err.Error()), nil
 }
 
+// Unwrap returns the inner error
+func (t TextErr) Unwrap() error {
+	return t.Err
+}
+
 var (
 	// ErrZeroValue is the error returned when variable has zero valud
 	// and nonzero was specified
@@ -61,39 +71,258 @@ var (
 	// ErrInvalidTypedValue is the error error returned when a passed value
 	// doesn't correspond with defined type
 	ErrInvalidTypedValue = TextErr{errors.New("invalid value for provided type")}
+	// ErrEqField is the error returned when variable is not equal
+	// to the field specified
+	ErrEqField = TextErr{errors.New("not equal to field")}
+	// ErrNeField is the error returned when variable is equal
+	// to the field specified
+	ErrNeField = TextErr{errors.New("equal to field")}
+	// ErrGtField is the error returned when variable is not greater
+	// than the field specified
+	ErrGtField = TextErr{errors.New("not greater than field")}
+	// ErrLtField is the error returned when variable is not less
+	// than the field specified
+	ErrLtField = TextErr{errors.New("not less than field")}
+	// ErrExcluded is the error returned when variable is not zero
+	// while it has to be absent
+	ErrExcluded = TextErr{errors.New("must be empty")}
+	// ErrCompare is the error returned when variable is not equal
+	// to the value specified
+	ErrCompare = TextErr{errors.New("not equal to expected value")}
+	// ErrNCompare is the error returned when variable is equal
+	// to the value specified
+	ErrNCompare = TextErr{errors.New("equal to forbidden value")}
+	// ErrAliasCycle is the error returned when a rule alias
+	// refers to itself directly or through other aliases
+	ErrAliasCycle = TextErr{errors.New("alias refers to itself")}
 
 	// tagRegexp is a regexp for tags extraction
 	tagRegexp = regexp.MustCompile("([^'=]+)=(?:'?)([^'=]*)(?:'?)(?:,|$)")
+	// tagMarkers are the tags without parameters separating
+	// sections of rules
+	tagMarkers = map[string]bool{tagDive: true, tagKeys: true, tagEndKeys: true}
 )
 
 const (
-	tagAttr = "attr"
+	tagAttr  = "attr"
+	tagLabel = "label"
+	tagMask  = "mask"
+	// tagDive is the marker separating the rules of a slice
+	// from the rules of its elements
+	tagDive = "dive"
+	// tagKeys and tagEndKeys are the markers enclosing the rules
+	// of map keys after dive
+	tagKeys    = "keys"
+	tagEndKeys = "endkeys"
+	// keySummary is the ErrorMap key of errors not related to a field
+	keySummary = "_summary"
+	// keyStruct is the ErrorMap key of the errors of the struct
+	// itself. Nested structs report them under their own path.
+	keyStruct = ""
 )
 
+// StructValidator is implemented by types which check their own
+// invariants. Validate calls ValidateStruct after the tag rules and
+// merges the errors it returns with the errors of the fields.
+type StructValidator interface {
+	ValidateStruct() ErrorMap
+}
+
+// Validatable is implemented by types which check their own
+// invariants. Validate calls it after the tag rules and reports
+// the error under the path of the struct: the empty key for the
+// validated struct itself and the field name for nested ones.
+// The method must not validate the same value with Validate.
+type Validatable interface {
+	Validate() error
+}
+
 // ErrorMap is a map which contains all errors from validating a struct.
 type ErrorMap map[string]error
 
-// ErrorMap implements the Error interface so we can check error against nil.
-// The returned error is if existent the first error which was added to the map.
+// String returns the same message as Error.
 func (err ErrorMap) String() string {
-	for k, err := range err {
-		if err != nil {
-			return fmt.Sprintf("%s: %s", k, err.Error())
+	return err.Error()
+}
+
+// ErrorMap implements the Error interface. The message lists the
+// errors of all fields in the path order, see Fields.
+func (err ErrorMap) Error() string {
+	msgs := make([]string, 0, len(err))
+	for _, k := range err.Fields() {
+		if k == keyStruct {
+			msgs = append(msgs, err[k].Error())
+			continue
 		}
+		msgs = append(msgs, fmt.Sprintf("%s: %s", k, err[k].Error()))
 	}
 
-	return ""
+	return strings.Join(msgs, "; ")
 }
 
+// Err returns the map as an error or nil if there are no errors,
+// so the result can be checked against nil.
+func (err ErrorMap) Err() error {
+	if err.IsEmpty() {
+		return nil
+	}
+	return err
+}
 
-func (err ErrorMap) Error() error {
-	for k, err := range err {
-		if err != nil {
-			return fmt.Errorf("%s: %s", k, err.Error())
+// Unwrap returns the errors of all fields in the path order.
+func (err ErrorMap) Unwrap() []error {
+	errs := make([]error, 0, len(err))
+	for _, k := range err.Fields() {
+		errs = append(errs, err[k])
+	}
+	return errs
+}
+
+// Is reports whether the error of any field matches the target,
+// so errors.Is(errs, ErrMin) finds a field that is less than min.
+func (err ErrorMap) Is(target error) bool {
+	return anyIs(err.Unwrap(), target)
+}
+
+// As finds the first error of the fields that matches the target
+// and sets the target to it.
+func (err ErrorMap) As(target interface{}) bool {
+	return anyAs(err.Unwrap(), target)
+}
+
+// Fields returns the keys of the failed fields in the path order:
+// the errors of the struct itself go first, the paths are compared
+// by their segments, elements by their index, and the summary goes
+// last. The order is the same for the same errors.
+func (err ErrorMap) Fields() []string {
+	return err.fieldsBy(nil)
+}
+
+// fieldsBy returns the keys of the errors sorted by the order
+func (err ErrorMap) fieldsBy(order keyOrder) []string {
+	keys := make([]string, 0, len(err))
+	for k, e := range err {
+		if e != nil {
+			keys = append(keys, k)
 		}
 	}
+	sort.Slice(keys, func(i, j int) bool {
+		return order.less(keys[i], keys[j])
+	})
+	return keys
+}
 
-	return nil
+// keyOrder holds the positions of the keys of an ErrorMap in the order
+// the validation adds them: the order of the fields in the plan, then
+// of the elements and the map keys. See ValidateOrdered.
+type keyOrder map[string]int
+
+// add records the position of the key, if it's new
+func (o keyOrder) add(key string) {
+	if _, exists := o[key]; !exists {
+		o[key] = len(o)
+	}
+}
+
+// less reports whether the key a goes before the key b: the errors
+// of the struct itself first, then the keys in the recorded order,
+// the keys added by hand in the path order and the summary last.
+func (o keyOrder) less(a, b string) bool {
+	switch {
+	case a == b:
+		return false
+	case a == keyStruct || b == keySummary:
+		return true
+	case b == keyStruct || a == keySummary:
+		return false
+	}
+
+	pa, okA := o[a]
+	pb, okB := o[b]
+	switch {
+	case okA && okB:
+		return pa < pb
+	case okA != okB:
+		return okA
+	}
+	return pathLess(a, b)
+}
+
+// Each calls fn for every error in the path order of the fields.
+// The errors of an ErrorArray are passed one by one.
+func (err ErrorMap) Each(fn func(field string, err error)) {
+	for _, k := range err.Fields() {
+		if arr, ok := err[k].(ErrorArray); ok {
+			for _, e := range arr {
+				fn(k, e)
+			}
+			continue
+		}
+		fn(k, err[k])
+	}
+}
+
+// Summary lists every error on a separate line in the path order
+// of the fields.
+func (err ErrorMap) Summary() string {
+	var b strings.Builder
+	err.Each(func(field string, err error) {
+		if field == keyStruct {
+			fmt.Fprintf(&b, "%s\n", err)
+			return
+		}
+		fmt.Fprintf(&b, "%s: %s\n", field, err)
+	})
+	return b.String()
+}
+
+// pathLess reports whether the key a goes before the key b in the
+// order of the paths: the field names alphabetically, the indexes
+// in the numeric order
+func pathLess(a, b string) bool {
+	pa, pb := splitPath(a), splitPath(b)
+	for i := 0; i < len(pa) && i < len(pb); i++ {
+		if pa[i] == pb[i] {
+			continue
+		}
+		ia, errA := strconv.Atoi(pa[i])
+		ib, errB := strconv.Atoi(pb[i])
+		if errA == nil && errB == nil {
+			return ia < ib
+		}
+		return pa[i] < pb[i]
+	}
+	return len(pa) < len(pb)
+}
+
+// splitPath splits the key into the field names and the indexes
+func splitPath(key string) []string {
+	return strings.FieldsFunc(key, func(r rune) bool {
+		return r == '.' || r == '[' || r == ']'
+	})
+}
+
+// add adds the errors of the field according to the mode. Errors
+// of map keys and values are reported under the same key, so they
+// are appended to the existing ones.
+func (err ErrorMap) add(key string, errs ErrorArray, mode Mode) {
+	if len(errs) == 0 {
+		return
+	}
+
+	prev, exists := err[key]
+	switch {
+	case mode&AllErrors == 0:
+		if !exists {
+			err[key] = errs[0]
+		}
+	case exists:
+		if arr, ok := prev.(ErrorArray); ok {
+			err[key] = append(arr, errs...)
+		}
+	default:
+		err[key] = errs
+	}
 }
 
 // IsEmpty returns true if the map consists no errors
@@ -113,17 +342,257 @@ func (err ErrorArray) Error() string {
 	return ""
 }
 
+// Unwrap returns the errors of the array.
+func (err ErrorArray) Unwrap() []error {
+	return err
+}
+
+// Is reports whether any of the errors matches the target.
+func (err ErrorArray) Is(target error) bool {
+	return anyIs(err, target)
+}
+
+// As finds the first error that matches the target and sets
+// the target to it.
+func (err ErrorArray) As(target interface{}) bool {
+	return anyAs(err, target)
+}
+
+// anyIs reports whether any of the errors matches the target
+func anyIs(errs []error, target error) bool {
+	for _, e := range errs {
+		if e != nil && errors.Is(e, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// anyAs finds the first of the errors that matches the target
+func anyAs(errs []error, target interface{}) bool {
+	for _, e := range errs {
+		if e != nil && errors.As(e, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// RuleErr is an error of a single rule. Fields validated in the
+// AllErrors mode report an ErrorArray of them.
+type RuleErr struct {
+	Rule string // name of the failed rule
+	Err  error
+}
+
+// Error implements the error interface.
+func (e RuleErr) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the error of the rule
+func (e RuleErr) Unwrap() error {
+	return e.Err
+}
+
+// ParamErr is the error of a rule parameter which can't be used,
+// e.g. an invalid regular expression. errors.Is matches it against
+// ErrBadParameter.
+type ParamErr struct {
+	Param string
+	Err   error
+}
+
+// Error implements the error interface.
+func (e ParamErr) Error() string {
+	return fmt.Sprintf("%s %q: %s", ErrBadParameter, e.Param, e.Err)
+}
+
+// Unwrap returns the cause of the error
+func (e ParamErr) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether the target is ErrBadParameter
+func (e ParamErr) Is(target error) bool {
+	return target == ErrBadParameter
+}
+
+// FieldError describes a failed rule of a field. Validate reports
+// them in the Detailed mode. errors.Is matches it against the rule
+// error, e.g. ErrMin, even if a custom message is used.
+type FieldError struct {
+	// Path is the key of the value in the ErrorMap, e.g. items[2].name
+	Path string
+	// Attr is the name or the attr alias of the field
+	Attr  string
+	Rule  string // name of the failed rule
+	Param string // parameter of the rule
+	// Value is the offending value, masked according to the mask tag
+	Value interface{}
+	// Default is the message of the rule error
+	Default string
+	// Message is the custom message of the rule (msg_<rule>), if any
+	Message string
+	// Placeholders holds the placeholders provided by the rule,
+	// e.g. allowed of the in rule
+	Placeholders map[string]string
+	// Err is the error returned by the rule
+	Err error
+}
+
+// Error returns the custom message if exists or the default one.
+func (e *FieldError) Error() string {
+	if e.Message != "" {
+		return e.Message
+	}
+	return e.Default
+}
+
+// Unwrap returns the error of the rule
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// Mode is a set of flags changing how Validate collects errors.
+type Mode uint8
+
+const (
+	// AllErrors makes Validate report every failing rule of a field
+	// as an ErrorArray of RuleErr instead of the first error only.
+	AllErrors Mode = 1 << iota
+	// FailFast makes Validate stop at the first failing field.
+	FailFast
+	// Detailed makes Validate report every failing rule as a
+	// *FieldError, in an ErrorArray if combined with AllErrors.
+	Detailed
+)
+
 // ValidationFunc is a function that receives the value of a
 // field and a parameter used for the respective validation tag.
 type ValidationFunc func(v interface{}, param string) error
 
+// Checker is a validation rule prepared for a parameter. It receives
+// the value of a field only.
+type Checker func(v interface{}) error
+
+// RuleFactory is a function that receives a parameter used for the
+// respective validation tag and returns a Checker for it. The parameter
+// is parsed once when the field rules are built, so a bad parameter is
+// reported by the factory instead of by every check.
+type RuleFactory func(param string) (Checker, error)
+
+// UnwrapFunc returns the value wrapped by an optional value and
+// reports whether it is present.
+type UnwrapFunc func(v interface{}) (interface{}, bool)
+
+// LeafFunc converts a value of a leaf struct type, such as a value
+// object, to the value the validation rules are applied to.
+type LeafFunc func(v interface{}) interface{}
+
+// ValidationFuncCtx is a function that receives the context of the
+// validation, the value of a field and a parameter used for the
+// respective validation tag.
+type ValidationFuncCtx func(ctx context.Context, v interface{}, param string) error
+
+// scope is the state of a validation passed to the prepared rules
+type scope struct {
+	ctx context.Context
+	// parent is the struct the validated field belongs to. It is
+	// invalid when a single value is validated.
+	parent reflect.Value
+	mode   Mode
+	// first is set if only the first failing rule is needed
+	first bool
+	// path is the key of the validated value in the ErrorMap
+	path string
+	// order records the order of the keys added to the ErrorMap
+	order keyOrder
+}
+
+// add adds the errors of the key to m according to the mode and
+// records the position of the key
+func (s scope) add(m ErrorMap, key string, errs ErrorArray) {
+	if len(errs) == 0 {
+		return
+	}
+	m.add(key, errs, s.mode)
+	s.order.add(key)
+}
+
+// checkFunc is a prepared rule as it's stored in the field rules
+type checkFunc func(s scope, v interface{}) error
+
+// factory builds a checkFunc for the parameter. All kinds of
+// registered rules are adapted to it. The parent is the type of the
+// struct the value belongs to and t is the type of the values the
+// rule is applied to, either is nil if it's unknown.
+type factory func(param string, parent, t reflect.Type) (checkFunc, error)
+
+// adaptFunc adapts a ValidationFunc to a factory
+func adaptFunc(vf ValidationFunc) factory {
+	return func(param string, _, _ reflect.Type) (checkFunc, error) {
+		return func(_ scope, v interface{}) error {
+			return vf(v, param)
+		}, nil
+	}
+}
+
+// adaptFuncCtx adapts a ValidationFuncCtx to a factory
+func adaptFuncCtx(vf ValidationFuncCtx) factory {
+	return func(param string, _, _ reflect.Type) (checkFunc, error) {
+		return func(s scope, v interface{}) error {
+			return vf(s.ctx, v, param)
+		}, nil
+	}
+}
+
+// adaptFactory adapts a RuleFactory to a factory
+func adaptFactory(rf RuleFactory) factory {
+	return func(param string, _, _ reflect.Type) (checkFunc, error) {
+		check, err := rf(param)
+		if err != nil {
+			return nil, err
+		}
+
+		return func(_ scope, v interface{}) error {
+			return check(v)
+		}, nil
+	}
+}
+
+// typedFactory adapts a builtin RuleFactory whose parameter depends
+// on the kind of the values to a factory. fits reports whether the
+// parameter can be used with the values of the type, so a bad one
+// fails when the rules are built.
+func typedFactory(rf RuleFactory, fits func(param string, t reflect.Type) error) factory {
+	f := adaptFactory(rf)
+	return func(param string, parent, t reflect.Type) (checkFunc, error) {
+		if t != nil {
+			if err := fits(param, t); err != nil {
+				return nil, err
+			}
+		}
+		return f(param, parent, t)
+	}
+}
+
 // Validator implements a validator
 type Validator struct {
-	// Tag name being used.
-	tagName string
-	// validationFuncs is a map of ValidationFuncs indexed
-	// by their name.
-	validationFuncs map[string]ValidationFunc
+	// gen is incremented on every configuration change, it goes
+	// first to be 64-bit aligned for the atomic operations
+	gen uint64
+	// parent is the validator the rules are inherited from
+	parent *Validator
+	// mu serializes configuration changes
+	mu sync.Mutex
+	// cfg holds the current *config layer
+	cfg atomic.Value
+	// regexps memoizes compiled regular expressions
+	regexps *regexpCache
+	// plans is a cache of compiled struct plans, used by the
+	// validators this one is the owner of
+	plans *planCache
 }
 
 // Helper validator so users can use the
@@ -132,19 +601,35 @@ var defaultValidator = NewValidator()
 
 // NewValidator creates a new Validator
 func NewValidator() *Validator {
-	return &Validator{
-		tagName: "validate",
-		validationFuncs: map[string]ValidationFunc{
-			"notempty": notZero,
-			"empty":    notZero,
-			"len":      length,
-			"min":      min,
-			"max":      max,
-			"regexp":   regex,
-			"in":       in,
-			"type":     typeValid,
-		},
+	mv := &Validator{
+		regexps: newRegexpCache(),
+		plans:   newPlanCache(),
+	}
+	mv.cfg.Store(&config{tagName: "validate"})
+
+	return mv
+}
+
+// Extend creates a new Validator which inherits all rules of the
+// default validator. Rules set on the new validator override the
+// inherited ones and don't affect the default validator.
+func Extend() *Validator {
+	return defaultValidator.Extend()
+}
+
+// Extend creates a new Validator which inherits all rules of mv,
+// including the ones set on mv later. Rules set on the new validator
+// override the inherited ones and don't affect mv.
+func (mv *Validator) Extend() *Validator {
+	v := &Validator{
+		parent:  mv,
+		regexps: mv.regexps,
+		plans:   newPlanCache(),
 	}
+	c := mv.config()
+	v.cfg.Store(&config{tagName: c.tagName, mode: c.mode})
+
+	return v
 }
 
 // SetTag allows you to change the tag name used in structs
@@ -154,7 +639,9 @@ func SetTag(tag string) {
 
 // SetTag allows you to change the tag name used in structs
 func (mv *Validator) SetTag(tag string) {
-	mv.tagName = tag
+	mv.update(func(c *config) {
+		c.tagName = tag
+	})
 }
 
 // WithTag creates a new Validator with the new tag name. It is
@@ -167,18 +654,37 @@ func WithTag(tag string) *Validator {
 // WithTag creates a new Validator with the new tag name. It is
 // useful to chain-call with Validate so we don't change the tag
 // name permanently: validator.WithTag("foo").Validate(t)
+// The new validator inherits the rules the same way as with Extend.
 func (mv *Validator) WithTag(tag string) *Validator {
-	v := mv.copy()
-	v.SetTag(tag)
+	v := mv.Extend()
+	v.cfg.Store(&config{tagName: tag, mode: mv.config().mode})
 	return v
 }
 
-// Copy a validator
-func (mv *Validator) copy() *Validator {
-	return &Validator{
-		tagName:         mv.tagName,
-		validationFuncs: mv.validationFuncs,
-	}
+// SetMode allows you to change the way errors are collected
+func SetMode(mode Mode) {
+	defaultValidator.SetMode(mode)
+}
+
+// SetMode allows you to change the way errors are collected
+func (mv *Validator) SetMode(mode Mode) {
+	mv.update(func(c *config) {
+		c.mode = mode
+	})
+}
+
+// WithMode creates a new Validator with the new mode, the same
+// way as WithTag: validator.WithMode(validator.AllErrors).Validate(t)
+func WithMode(mode Mode) *Validator {
+	return defaultValidator.WithMode(mode)
+}
+
+// WithMode creates a new Validator with the new mode, the same
+// way as WithTag: validator.WithMode(validator.AllErrors).Validate(t)
+func (mv *Validator) WithMode(mode Mode) *Validator {
+	v := mv.Extend()
+	v.cfg.Store(&config{tagName: mv.config().tagName, mode: mode})
+	return v
 }
 
 // SetValidationFunc sets the function to be used for a given
@@ -192,14 +698,236 @@ func SetValidationFunc(name string, vf ValidationFunc) error {
 // validation constraint. Calling this function with nil vf
 // is the same as removing the constraint function from the list.
 func (mv *Validator) SetValidationFunc(name string, vf ValidationFunc) error {
+	if vf == nil {
+		return mv.setRule(name, nil)
+	}
+	return mv.setRule(name, adaptFunc(vf))
+}
+
+// SetValidationFuncCtx sets the context-aware function to be used for
+// a given validation constraint. The function receives the context
+// passed to ValidateCtx or ValidCtx. Calling this function with nil vf
+// is the same as removing the constraint function from the list.
+func SetValidationFuncCtx(name string, vf ValidationFuncCtx) error {
+	return defaultValidator.SetValidationFuncCtx(name, vf)
+}
+
+// SetValidationFuncCtx sets the context-aware function to be used for
+// a given validation constraint. The function receives the context
+// passed to ValidateCtx or ValidCtx. Calling this function with nil vf
+// is the same as removing the constraint function from the list.
+func (mv *Validator) SetValidationFuncCtx(name string, vf ValidationFuncCtx) error {
+	if vf == nil {
+		return mv.setRule(name, nil)
+	}
+	return mv.setRule(name, adaptFuncCtx(vf))
+}
+
+// SetRuleFactory sets the factory to be used for a given
+// validation constraint. Calling this function with nil rf
+// is the same as removing the constraint from the list.
+func SetRuleFactory(name string, rf RuleFactory) error {
+	return defaultValidator.SetRuleFactory(name, rf)
+}
+
+// SetRuleFactory sets the factory to be used for a given
+// validation constraint. Calling this function with nil rf
+// is the same as removing the constraint from the list.
+func (mv *Validator) SetRuleFactory(name string, rf RuleFactory) error {
+	if rf == nil {
+		return mv.setRule(name, nil)
+	}
+	return mv.setRule(name, adaptFactory(rf))
+}
+
+// setRule sets the rule in the local layer of the validator.
+// A nil factory removes the rule.
+func (mv *Validator) setRule(name string, f factory) error {
 	if name == "" {
 		return errors.New("name cannot be empty")
 	}
-	if vf == nil {
-		delete(mv.validationFuncs, name)
-		return nil
+	mv.update(func(c *config) {
+		c.rules[name] = f
+	})
+	return nil
+}
+
+// RegisterPattern compiles the regular expression and registers it
+// under the name, so it can be used as regexp=@name in tags.
+func RegisterPattern(name, expr string) error {
+	return defaultValidator.RegisterPattern(name, expr)
+}
+
+// RegisterPattern compiles the regular expression and registers it
+// under the name, so it can be used as regexp=@name in tags.
+func (mv *Validator) RegisterPattern(name, expr string) error {
+	if name == "" {
+		return errors.New("name cannot be empty")
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return err
 	}
-	mv.validationFuncs[name] = vf
+	mv.update(func(c *config) {
+		c.patterns[name] = re
+	})
+	return nil
+}
+
+// RegisterLeafType makes the struct type of v validated as a single
+// value instead of being walked into like a nested struct. The convert
+// function, if not nil, is applied to the values before the rules, e.g.
+// to turn them into strings or numbers the builtin rules understand.
+func RegisterLeafType(v interface{}, convert LeafFunc) error {
+	return defaultValidator.RegisterLeafType(v, convert)
+}
+
+// RegisterLeafType makes the struct type of v validated as a single
+// value instead of being walked into like a nested struct. The convert
+// function, if not nil, is applied to the values before the rules, e.g.
+// to turn them into strings or numbers the builtin rules understand.
+func (mv *Validator) RegisterLeafType(v interface{}, convert LeafFunc) error {
+	t := reflect.TypeOf(v)
+	if t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return errors.New("leaf type must be a struct")
+	}
+	mv.update(func(c *config) {
+		c.leaves[t] = convert
+	})
+	return nil
+}
+
+// RegisterWrapper registers the type of v as an optional value, which
+// is validated by the value it wraps. Absent values are validated as
+// nil. Values implementing driver.Valuer, such as sql.NullString, are
+// unwrapped without being registered.
+func RegisterWrapper(v interface{}, unwrap UnwrapFunc) error {
+	return defaultValidator.RegisterWrapper(v, unwrap)
+}
+
+// RegisterWrapper registers the type of v as an optional value, which
+// is validated by the value it wraps. Absent values are validated as
+// nil. Values implementing driver.Valuer, such as sql.NullString, are
+// unwrapped without being registered.
+func (mv *Validator) RegisterWrapper(v interface{}, unwrap UnwrapFunc) error {
+	t := reflect.TypeOf(v)
+	if t == nil || t.Kind() == reflect.Ptr {
+		return errors.New("wrapper type cannot be a pointer")
+	}
+	if unwrap == nil {
+		return errors.New("unwrap function cannot be nil")
+	}
+	mv.update(func(c *config) {
+		c.wrappers[t] = unwrap
+	})
+	return nil
+}
+
+// RegisterStructRules attaches rules to the fields of the struct type
+// of v as if they were given in the field tags. It is meant for types
+// which can't be tagged, e.g. generated ones. The rules are merged with
+// the tag of the field: a rule replaces the tag's one with the same name
+// and the rules following dive replace the element rules. The "-" rules
+// exclude the field from validation.
+func RegisterStructRules(v interface{}, rules map[string]string) error {
+	return defaultValidator.RegisterStructRules(v, rules)
+}
+
+// RegisterStructRules attaches rules to the fields of the struct type
+// of v as if they were given in the field tags. It is meant for types
+// which can't be tagged, e.g. generated ones. The rules are merged with
+// the tag of the field: a rule replaces the tag's one with the same name
+// and the rules following dive replace the element rules. The "-" rules
+// exclude the field from validation.
+func (mv *Validator) RegisterStructRules(v interface{}, rules map[string]string) error {
+	t := reflect.TypeOf(v)
+	if t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return errors.New("rules can be registered only for structs")
+	}
+	for name := range rules {
+		// promoted fields have to be registered for their own type
+		if sf, exists := t.FieldByName(name); !exists || len(sf.Index) > 1 {
+			return fmt.Errorf("unknown field %s of %s", name, t)
+		}
+	}
+
+	mv.update(func(c *config) {
+		fields := make(map[string]string, len(c.structRules[t])+len(rules))
+		for k, v := range c.structRules[t] {
+			fields[k] = v
+		}
+		for k, v := range rules {
+			fields[k] = v
+		}
+		c.structRules[t] = fields
+	})
+	return nil
+}
+
+// RegisterAlias registers a name standing for a list of rules, so
+// that tags may refer to it as a bare name, e.g. validate:"username".
+// The rules keep their own names for custom messages and errors.
+// Aliases take precedence over the rules with the same name.
+func RegisterAlias(name, tags string) error {
+	return defaultValidator.RegisterAlias(name, tags)
+}
+
+// RegisterAlias registers a name standing for a list of rules, so
+// that tags may refer to it as a bare name, e.g. validate:"username".
+// The rules keep their own names for custom messages and errors.
+// Aliases take precedence over the rules with the same name.
+func (mv *Validator) RegisterAlias(name, tags string) error {
+	if name == "" || strings.ContainsAny(name, "=,' ") {
+		return errors.New("invalid alias name")
+	}
+
+	// the alias may not refer to itself through the others
+	expanded, err := mv.expandTags(tags, map[string]bool{name: true})
+	if err != nil {
+		return err
+	}
+	for _, t := range expanded {
+		if tagMarkers[t.Name] {
+			return ErrBadParameter
+		}
+	}
+
+	mv.update(func(c *config) {
+		c.aliases[name] = tags
+	})
+	return nil
+}
+
+// RegisterTypeRules attaches rules to the type, so they apply to every
+// field, element or map value of the type as if they were given in its
+// tags. The tags of the field are added on top of them, a rule of the
+// field replaces the registered one with the same name. Empty tags
+// remove the rules registered for the type.
+func RegisterTypeRules(t reflect.Type, tags string) error {
+	return defaultValidator.RegisterTypeRules(t, tags)
+}
+
+// RegisterTypeRules attaches rules to the type, so they apply to every
+// field, element or map value of the type as if they were given in its
+// tags. The tags of the field are added on top of them, a rule of the
+// field replaces the registered one with the same name. Empty tags
+// remove the rules registered for the type.
+func (mv *Validator) RegisterTypeRules(t reflect.Type, tags string) error {
+	if t == nil {
+		return errors.New("type cannot be nil")
+	}
+	if _, err := mv.parseTags(tags); err != nil {
+		return err
+	}
+	mv.update(func(c *config) {
+		c.typeRules[t] = tags
+	})
 	return nil
 }
 
@@ -214,82 +942,415 @@ func Validate(v interface{}) ErrorMap {
 // on 'validator' tags and returns errors found indexed
 // by the field name.
 func (mv *Validator) Validate(v interface{}) ErrorMap {
+	return mv.ValidateCtx(context.Background(), v)
+}
+
+// ValidateCtx validates the fields of a struct the same way as
+// Validate, passing ctx to the context-aware validation functions.
+// Once ctx is done the validation stops and the context's error
+// is returned under the _summary key. If ctx has a locale (see
+// WithLocale), the errors are translated to the locale, see Translate.
+// The messages are looked up with the rule details in every mode, the
+// errors are still reported in the mode of the validator.
+func ValidateCtx(ctx context.Context, v interface{}) ErrorMap {
+	return defaultValidator.ValidateCtx(ctx, v)
+}
+
+// ValidateCtx validates the fields of a struct the same way as
+// Validate, passing ctx to the context-aware validation functions.
+// Once ctx is done the validation stops and the context's error
+// is returned under the _summary key. If ctx has a locale (see
+// WithLocale), the errors are translated to the locale, see Translate.
+// The messages are looked up with the rule details in every mode, the
+// errors are still reported in the mode of the validator.
+func (mv *Validator) ValidateCtx(ctx context.Context, v interface{}) ErrorMap {
+	mode := mv.config().mode
+	if locale, ok := localeFrom(ctx); ok {
+		// the rule details are needed to look up the messages,
+		// the errors are still reported in the mode
+		m, _ := mv.validate(ctx, v, mode|Detailed)
+		return mv.translate(m, locale, mode)
+	}
+	m, _ := mv.validate(ctx, v, mode)
+	return m
+}
+
+// ValidateOrdered validates the fields of a struct the same way as
+// Validate and also returns the keys of the errors in the order of
+// the fields: the errors of the struct itself first, then the fields
+// in the declaration order with nested paths and elements in the
+// traversal order, and the summary last.
+func ValidateOrdered(v interface{}) (ErrorMap, []string) {
+	return defaultValidator.ValidateOrdered(v)
+}
+
+// ValidateOrdered validates the fields of a struct the same way as
+// Validate and also returns the keys of the errors in the order of
+// the fields, see the ValidateOrdered function.
+func (mv *Validator) ValidateOrdered(v interface{}) (ErrorMap, []string) {
+	m, order := mv.validate(context.Background(), v, mv.config().mode)
+	return m, m.fieldsBy(order)
+}
+
+// validate validates the fields of a struct in the mode. It returns
+// the errors and the order of their keys.
+func (mv *Validator) validate(ctx context.Context, v interface{}, mode Mode) (ErrorMap, keyOrder) {
 	var (
-		sv = reflect.ValueOf(v)
-		st = reflect.TypeOf(v)
-		m  = make(ErrorMap)
+		sv    = reflect.ValueOf(v)
+		st    = reflect.TypeOf(v)
+		m     = make(ErrorMap)
+		order = make(keyOrder)
 	)
 
 	if sv.Kind() == reflect.Ptr && !sv.IsNil() {
-		return mv.Validate(sv.Elem().Interface())
+		return mv.validate(ctx, sv.Elem().Interface(), mode)
 	}
-	if sv.Kind() != reflect.Struct {
-		m["_summary"] = ErrUnsupported
-		return m
+	switch sv.Kind() {
+	case reflect.Struct:
+	case reflect.Slice, reflect.Array, reflect.Map:
+		if !mv.walkable(st) {
+			m[keySummary] = ErrUnsupported
+			return m, order
+		}
+	default:
+		m[keySummary] = ErrUnsupported
+		return m, order
 	}
 
-	nfields := sv.NumField()
-	for i := 0; i < nfields; i++ {
-		var (
-			f     = sv.Field(i)
-			fname = st.Field(i).Name
-			errs  ErrorArray
-		)
+	var (
+		plan = mv.planFor(st)
+		s    = scope{ctx: ctx, mode: mode, first: mode&AllErrors == 0, order: order}
+	)
+
+	// slices, arrays and maps of structs
+	if plan.value != nil {
+		mv.validateValue(s, m, "", sv, plan.value)
+		if err := ctx.Err(); err != nil {
+			m[keySummary] = err
+		}
+		return m, order
+	}
 
-		// deal with pointers
-		for f.Kind() == reflect.Ptr && !f.IsNil() {
-			f = f.Elem()
+	s.parent = sv
+	for _, fp := range plan.fields {
+		if err := ctx.Err(); err != nil {
+			m[keySummary] = err
+			return m, order
 		}
 
-		tag := st.Field(i).Tag.Get(mv.tagName)
-		if tag == "-" || (tag == "" && f.Kind() != reflect.Struct) {
-			continue
+		if fp.err != nil {
+			s.add(m, fp.name, ErrorArray{fp.err})
+		} else if f, ok := fieldByIndex(sv, fp.index); ok {
+			mv.validateValue(s, m, fp.name, f, fp.value)
 		}
 
-		// parse tags on the highest level to pass further
-		tags, err := mv.parseTags(tag)
-		if err != nil {
-			m[fname] = err
+		if mode&FailFast != 0 && len(m) > 0 {
+			break
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		m[keySummary] = err
+		return m, order
+	}
+
+	if mode&FailFast == 0 || len(m) == 0 {
+		validateSelf(s, m, sv)
+	}
+
+	return m, order
+}
+
+// Check builds the validation plan of the type of v, which is
+// otherwise built on the first validation, and returns the errors of
+// its tags, e.g. unknown rules or invalid regular expressions, as an
+// ErrorMap indexed by the field path. Nested structs and elements are
+// checked as well. The result is nil if all the tags are valid.
+func Check(v interface{}) error {
+	return defaultValidator.Check(v)
+}
+
+// Check builds the validation plan of the type of v, which is
+// otherwise built on the first validation, and returns the errors of
+// its tags, e.g. unknown rules or invalid regular expressions, as an
+// ErrorMap indexed by the field path. Nested structs and elements are
+// checked as well. The result is nil if all the tags are valid.
+func (mv *Validator) Check(v interface{}) error {
+	t := reflect.TypeOf(v)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	m := make(ErrorMap)
+	if t == nil || (t.Kind() != reflect.Struct && !mv.walkable(t)) {
+		m[keySummary] = ErrUnsupported
+		return m
+	}
+	mv.checkType(m, "", t, map[reflect.Type]bool{})
+	return m.Err()
+}
+
+// checkType adds the errors of the plan of the struct type or of the
+// slice, array or map of structs to m. The keys are prefixed by the
+// path of the value.
+func (mv *Validator) checkType(m ErrorMap, prefix string, t reflect.Type, visited map[reflect.Type]bool) {
+	// struct types may refer to themselves
+	if visited[t] {
+		return
+	}
+	visited[t] = true
+	defer delete(visited, t)
+
+	plan := mv.planFor(t)
+	if plan.value != nil {
+		mv.checkValue(m, prefix, t, plan.value, visited)
+		return
+	}
+
+	for _, fp := range plan.fields {
+		key := fp.name
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		if fp.err != nil {
+			m[key] = fp.err
 			continue
 		}
+		mv.checkValue(m, key, t.FieldByIndex(fp.index).Type, fp.value, visited)
+	}
+}
+
+// checkValue adds the errors of the value plan of the type to m
+func (mv *Validator) checkValue(m ErrorMap, key string, t reflect.Type, vp *valuePlan, visited map[reflect.Type]bool) {
+	if vp.rulesErr != nil {
+		m[key] = vp.rulesErr
+		return
+	}
+
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		if !vp.promoted && !mv.isLeaf(t) {
+			mv.checkType(m, key, t, visited)
+		}
+	case reflect.Map:
+		if vp.keys != nil {
+			mv.checkValue(m, key, t.Key(), vp.keys, visited)
+		}
+		fallthrough
+	case reflect.Slice, reflect.Array:
+		if vp.elem != nil {
+			mv.checkValue(m, key, t.Elem(), vp.elem, visited)
+		}
+	}
+}
+
+// validateSelf calls the StructValidator and Validatable methods
+// of the struct value and adds the errors they return to m. Methods
+// promoted through a nil embedded pointer are skipped.
+func validateSelf(s scope, m ErrorMap, sv reflect.Value) {
+	// methods with pointer receivers need an addressable value
+	if !sv.CanAddr() {
+		p := reflect.New(sv.Type())
+		p.Elem().Set(sv)
+		sv = p.Elem()
+	}
+	v := sv.Addr().Interface()
+
+	if vs, ok := v.(StructValidator); ok && !promotedThroughNil(sv, "ValidateStruct") {
+		errs := vs.ValidateStruct()
+		for _, k := range errs.Fields() {
+			s.add(m, k, asErrorArray(errs[k]))
+		}
+	}
+	if vv, ok := v.(Validatable); ok && !promotedThroughNil(sv, "Validate") {
+		s.add(m, keyStruct, asErrorArray(vv.Validate()))
+	}
+}
+
+// promotedThroughNil reports whether the method of the addressable
+// struct value is promoted from an embedded struct behind a nil
+// pointer. The method is looked up in the embedded structs by depth
+// the same way Go promotes it. A method declared by the struct itself
+// is taken for promoted if an embedded struct has it as well.
+func promotedThroughNil(sv reflect.Value, name string) bool {
+	// embedded is a struct found on the way, its value is invalid
+	// behind a nil pointer
+	type embedded struct {
+		t reflect.Type
+		v reflect.Value
+	}
+
+	level := []embedded{{t: sv.Type(), v: sv}}
+	for len(level) > 0 {
+		var (
+			next  []embedded
+			found int
+			isNil bool
+		)
+		for _, e := range level {
+			for i := 0; i < e.t.NumField(); i++ {
+				sf := e.t.Field(i)
+				if !sf.Anonymous {
+					continue
+				}
+
+				var (
+					ft = sf.Type
+					mt = ft // type of the method set
+					f  reflect.Value
+				)
+				if e.v.IsValid() {
+					f = e.v.Field(i)
+				}
+				switch ft.Kind() {
+				case reflect.Ptr:
+					ft, mt = ft.Elem(), ft
+					if f.IsValid() && f.IsNil() {
+						f = reflect.Value{}
+					} else if f.IsValid() {
+						f = f.Elem()
+					}
+				case reflect.Interface:
+					if f.IsValid() && f.IsNil() {
+						f = reflect.Value{}
+					}
+				default:
+					mt = reflect.PtrTo(ft)
+				}
 
-		// custom field alias
-		if nameTag, exists := tags.getByName(tagAttr); exists {
-			fname = nameTag.Param
+				if _, ok := mt.MethodByName(name); ok {
+					found++
+					isNil = !f.IsValid()
+					continue
+				}
+				if ft.Kind() == reflect.Struct {
+					next = append(next, embedded{t: ft, v: f})
+				}
+			}
+		}
+		// methods found twice on the same depth aren't promoted,
+		// so the struct declares its own one
+		if found > 0 {
+			return found == 1 && isNil
 		}
+		level = next
+	}
+	return false
+}
 
-		switch f.Kind() {
-		// nested struct
-		case reflect.Struct:
-			if !unicode.IsUpper(rune(fname[0])) {
+// asErrorArray returns the error as an ErrorArray
+func asErrorArray(err error) ErrorArray {
+	if err == nil {
+		return nil
+	}
+	if errs, ok := err.(ErrorArray); ok {
+		return errs
+	}
+	return ErrorArray{err}
+}
+
+// validateValue validates a field or an element value according
+// to the plan and adds the errors found to m under the key.
+func (mv *Validator) validateValue(s scope, m ErrorMap, key string, f reflect.Value, vp *valuePlan) {
+	// deal with pointers
+	for f.Kind() == reflect.Ptr && !f.IsNil() {
+		f = f.Elem()
+	}
+
+	var errs ErrorArray
+	switch {
+	// nested struct
+	case f.Kind() == reflect.Struct && !mv.isLeaf(f.Type()):
+		// fields of embedded structs are validated with the parent
+		if vp.promoted {
+			return
+		}
+		e, order := mv.validate(s.ctx, f.Interface(), s.mode)
+		for _, j := range e.fieldsBy(order) {
+			if j == keySummary {
 				continue
 			}
+			// Nested struct gets alias of parent struct
+			// as a prefix
+			k := e[j]
+			prefixPaths(k, key)
+			path := key
+			if j != keyStruct {
+				path = key + "." + j
+			}
+			m[path] = k
+			s.order.add(path)
+		}
+		return
+
+	case vp.rulesErr != nil:
+		errs = ErrorArray{vp.rulesErr}
 
-			e := mv.Validate(f.Interface())
-			for j, k := range e {
-				// Nested struct gets alias of parent struct
-				// as a prefix
-				m[fname+"."+j] = k
+		// flat value
+	case vp.tagged:
+		s.path = key
+		err := mv.valid(s, f.Interface(), vp.rules)
+		if errors, ok := err.(ErrorArray); ok {
+			errs = errors
+		} else {
+			if err != nil {
+				errs = ErrorArray{err}
 			}
+		}
+	}
+	s.add(m, key, errs)
 
-			// flat struct
-		default:
-			err := mv.valid(f.Interface(), tags)
-			if errors, ok := err.(ErrorArray); ok {
-				errs = errors
-			} else {
-				if err != nil {
-					errs = ErrorArray{err}
-				}
+	switch f.Kind() {
+	case reflect.Slice, reflect.Array:
+		if vp.elem == nil {
+			return
+		}
+		for i := 0; i < f.Len(); i++ {
+			if s.done(m) {
+				return
 			}
+			mv.validateValue(s, m, key+"["+strconv.Itoa(i)+"]", f.Index(i), vp.elem)
+		}
+
+	case reflect.Map:
+		if vp.elem == nil && vp.keys == nil {
+			return
 		}
+		for _, k := range sortedKeys(f) {
+			if s.done(m) {
+				return
+			}
 
-		if len(errs) > 0 {
-			m[fname] = errs[0]
+			ek := fmt.Sprintf("%s[%v]", key, k.Interface())
+			if vp.keys != nil {
+				mv.validateValue(s, m, ek, k, vp.keys)
+			}
+			if vp.elem != nil {
+				mv.validateValue(s, m, ek, f.MapIndex(k), vp.elem)
+			}
 		}
 	}
+}
 
-	return m
+// done returns true if the validation has to stop: the context
+// is done or an error is found in the FailFast mode
+func (s scope) done(m ErrorMap) bool {
+	return s.ctx.Err() != nil || (s.mode&FailFast != 0 && len(m) > 0)
+}
+
+// sortedKeys returns the keys of the map in a deterministic order
+func sortedKeys(f reflect.Value) []reflect.Value {
+	keys := f.MapKeys()
+	sort.Slice(keys, func(i, j int) bool {
+		if c, err := compareValues(keys[i], keys[j]); err == nil && c != unordered {
+			return c < 0
+		}
+		return fmt.Sprint(keys[i].Interface()) < fmt.Sprint(keys[j].Interface())
+	})
+
+	return keys
 }
 
 // Valid validates a value based on the provided
@@ -301,63 +1362,169 @@ func Valid(val interface{}, tags string) error {
 // Valid validates a value based on the *raw string*
 // tags and returns errors found or nil.
 func (mv *Validator) Valid(val interface{}, tagsRaw string) error {
+	return mv.ValidCtx(context.Background(), val, tagsRaw)
+}
+
+// ValidCtx validates a value the same way as Valid, passing ctx
+// to the context-aware validation functions.
+func ValidCtx(ctx context.Context, val interface{}, tags string) error {
+	return defaultValidator.ValidCtx(ctx, val, tags)
+}
+
+// ValidCtx validates a value the same way as Valid, passing ctx
+// to the context-aware validation functions.
+func (mv *Validator) ValidCtx(ctx context.Context, val interface{}, tagsRaw string) error {
 	if tagsRaw == "-" {
 		return nil
 	}
 
 	tags, err := mv.parseTags(tagsRaw)
+	if err != nil {
+		return err
+	}
+
+	// rules registered for the type of the value, but not for
+	// its elements
+	typeTags, err := mv.typeRules(reflect.TypeOf(val))
+	if err != nil {
+		return err
+	}
+	if len(typeTags) > 0 {
+		typeTags, _ = typeTags.cut(tagDive)
+		tags = typeTags.merge(tags)
+	}
+
+	// elements can't be reported without a struct
+	if len(tags.split(tagDive)) > 1 {
+		return ErrUnsupported
+	}
+
+	rules, err := mv.compileRules(nil, "", reflect.TypeOf(val), tags)
 	if err != nil {
 		// unknown tag found, give up.
 		return err
 	}
 
-	return mv.valid(val, tags)
+	return mv.valid(scope{ctx: ctx}, val, rules)
 }
 
 // Valid validates a value based on the provided
-// tags and returns errors found or nil.
-func (mv *Validator) valid(val interface{}, tags tagList) error {
+// rules and returns errors found or nil.
+func (mv *Validator) valid(s scope, val interface{}, rules ruleList) error {
 	v := reflect.ValueOf(val)
 	if v.Kind() == reflect.Ptr && !v.IsNil() {
-		return mv.valid(v.Elem().Interface(), tags)
+		return mv.valid(s, v.Elem().Interface(), rules)
+	}
+
+	// optional values are validated by the value they wrap,
+	// absent ones by the presence rules only
+	u, ok, err := mv.unwrap(val)
+	if err != nil {
+		return err
+	}
+	switch {
+	case ok && u == nil:
+		return mv.validateVar(s, nil, rules.presence())
+	case ok:
+		return mv.valid(s, u, rules)
 	}
 
-	var err error
 	switch v.Kind() {
 	case reflect.Struct:
-		return ErrUnsupported
+		convert, ok := mv.lookupLeaf(v.Type())
+		if !ok {
+			return ErrUnsupported
+		}
+		if convert != nil {
+			val = convert(val)
+		}
+		err = mv.validateVar(s, val, rules)
 	case reflect.Invalid:
-		err = mv.validateVar(nil, tags)
+		err = mv.validateVar(s, nil, rules)
 	default:
-		err = mv.validateVar(val, tags)
+		err = mv.validateVar(s, val, rules)
 	}
 
 	return err
 }
 
-// validateVar validates one single variable
-func (mv *Validator) validateVar(v interface{}, tags tagList) error {
-	errs := make(ErrorArray, 0, len(tags))
-	for _, t := range tags {
-		fn, found := mv.validationFuncs[t.Name]
-		if !found {
-			// skip additional tags
-			if strings.HasPrefix(t.Name, "msg_") || t.Name == tagAttr {
-				continue
-			}
+// prefixPaths prefixes the paths of the field errors of a nested
+// struct with its key
+func prefixPaths(err error, prefix string) {
+	switch e := err.(type) {
+	case *FieldError:
+		if e.Path == "" {
+			e.Path = prefix
+		} else {
+			e.Path = prefix + "." + e.Path
+		}
+	case ErrorArray:
+		for _, err := range e {
+			prefixPaths(err, prefix)
+		}
+	}
+}
+
+// unwrap resolves an optional value to the value it wraps. It reports
+// false if the value is neither a registered wrapper nor a nullable
+// struct like sql.NullString. Absent values are resolved to nil.
+func (mv *Validator) unwrap(v interface{}) (interface{}, bool, error) {
+	t := reflect.TypeOf(v)
+	if t == nil || t.Kind() == reflect.Ptr {
+		return v, false, nil
+	}
+
+	if f, exists := mv.lookupWrapper(t); exists {
+		u, present := f(v)
+		if !present {
+			return nil, true, nil
+		}
+		return u, true, nil
+	}
+
+	// other driver.Valuer types, e.g. arrays, have their own
+	// representation the rules don't understand
+	if !isNullable(t) {
+		return v, false, nil
+	}
+	u, err := v.(driver.Valuer).Value()
+	if err != nil {
+		return nil, false, err
+	}
+	// the value can't be resolved any further
+	if reflect.TypeOf(u) == t {
+		return v, false, nil
+	}
+	return u, true, nil
+}
 
-			return ErrUnknownTag
+// validateVar validates one single variable
+func (mv *Validator) validateVar(s scope, v interface{}, rules ruleList) error {
+	errs := make(ErrorArray, 0, len(rules))
+	for _, r := range rules {
+		if err := s.ctx.Err(); err != nil {
+			return err
 		}
 
-		if err := fn(v, t.Param); err != nil {
+		if err := r.check(s, v); err != nil {
+			err, placeholders := splitPlaceholders(err)
+
 			// custom error message
-			errTag, exists := tags.getByName(fmt.Sprintf("msg_%s", t.Name))
-			if exists {
-				errMsg := strings.Replace(errTag.Param, "{param}", t.Param, -1)
-				err = errors.New(errMsg)
+			var msg string
+			if r.msg != nil {
+				msg = r.msg.format(v, placeholders)
+			}
+
+			if s.mode&Detailed != 0 {
+				err = r.fieldError(s.path, v, err, msg, placeholders)
+			} else {
+				err = plainError(r.Name, err, msg, r.msg != nil, s.mode)
 			}
 
 			errs = append(errs, err)
+			if s.first {
+				break
+			}
 		}
 	}
 	if len(errs) > 0 {
@@ -367,6 +1534,137 @@ func (mv *Validator) validateVar(v interface{}, tags tagList) error {
 	return nil
 }
 
+// rule is a tag bound to its prepared checker
+type rule struct {
+	tag
+	check checkFunc
+	// msg is a custom error message (msg_<rule>) if exists
+	msg *message
+	// field is the name or the attr alias of the field
+	field string
+	// mask is the number of trailing characters of the value
+	// shown in errors, see message
+	mask int
+}
+
+// fieldError describes the failure of the rule
+func (r rule) fieldError(path string, v interface{}, err error, msg string, placeholders map[string]string) *FieldError {
+	if r.mask != noMask {
+		v = maskValue(v, r.mask)
+	}
+	return &FieldError{
+		Path:         path,
+		Attr:         r.field,
+		Rule:         r.Name,
+		Param:        r.Param,
+		Value:        v,
+		Default:      err.Error(),
+		Message:      msg,
+		Placeholders: placeholders,
+		Err:          err,
+	}
+}
+
+// plainError returns the error of the failed rule reported without
+// the details: the custom message instead of the rule error, if the
+// rule has one, as a RuleErr of the rule in the AllErrors mode.
+func plainError(rule string, err error, msg string, custom bool, mode Mode) error {
+	if custom {
+		err = errors.New(msg)
+	}
+	if mode&AllErrors != 0 {
+		return RuleErr{Rule: rule, Err: err}
+	}
+	return err
+}
+
+// ruleList is a list of rules of a single field
+type ruleList []rule
+
+// presenceRules are the builtin rules checking the presence of a
+// value, the only ones applied to absent optional values
+var presenceRules = map[string]bool{
+	"notempty":         true,
+	"empty":            true,
+	"required_if":      true,
+	"required_with":    true,
+	"required_without": true,
+	"excluded_if":      true,
+	"compare":          true,
+}
+
+// presence returns the rules checking the presence of the value
+func (rl ruleList) presence() ruleList {
+	res := make(ruleList, 0, len(rl))
+	for _, r := range rl {
+		if presenceRules[r.tag.Name] {
+			res = append(res, r)
+		}
+	}
+	return res
+}
+
+// compileRules prepares checkers for the parsed tags of the field of
+// the parent struct type, nil for single values. The field is of
+// type t, nil if it's unknown. Additional tags (attr, msg_*) are
+// attached to the rules they refer to.
+func (mv *Validator) compileRules(parent reflect.Type, field string, t reflect.Type, tags tagList) (ruleList, error) {
+	mask, err := parseMask(tags)
+	if err != nil {
+		return nil, err
+	}
+	vt := mv.ruleType(t)
+
+	rules := make(ruleList, 0, len(tags))
+	for _, t := range tags {
+		f, found := mv.lookupRule(t.Name)
+		if !found {
+			// skip additional tags
+			if strings.HasPrefix(t.Name, "msg_") || t.Name == tagAttr || t.Name == tagLabel || t.Name == tagMask {
+				continue
+			}
+
+			return nil, ErrUnknownTag
+		}
+
+		check, err := f(t.Param, parent, vt)
+		if err != nil {
+			return nil, err
+		}
+
+		r := rule{tag: t, check: check, field: field, mask: mask}
+
+		// custom error message
+		if errTag, exists := tags.getByName(fmt.Sprintf("msg_%s", t.Name)); exists {
+			r.msg = newMessage(errTag.Param, field, t, tags, mask)
+		}
+
+		rules = append(rules, r)
+	}
+
+	return rules, nil
+}
+
+// ruleType returns the type of the values the rules of a value of
+// type t are applied to. It's nil if the type is known at the
+// validation only: for interfaces, optional values which are
+// unwrapped first and leaf values converted by a LeafFunc.
+func (mv *Validator) ruleType(t reflect.Type) reflect.Type {
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() == reflect.Interface || isNullable(t) {
+		return nil
+	}
+	if _, exists := mv.lookupWrapper(t); exists {
+		return nil
+	}
+	if f, exists := mv.lookupLeaf(t); exists && f != nil {
+		return nil
+	}
+	return t
+}
+
 // tag represents one of the tag items
 type tag struct {
 	Name  string // name of the tag
@@ -387,12 +1685,153 @@ func (tl tagList) getByName(name string) (tag, bool) {
 	return tag{}, false
 }
 
+// regexpRule is the builtin rule that checks whether the string
+// variable matches a regular expression. The expression is compiled
+// (or the named one is resolved) once.
+func (mv *Validator) regexpRule(param string) (Checker, error) {
+	var (
+		re  *regexp.Regexp
+		err error
+	)
+	if strings.HasPrefix(param, "@") {
+		var exists bool
+		if re, exists = mv.lookupPattern(param[1:]); !exists {
+			return nil, ParamErr{Param: param, Err: errors.New("unknown pattern")}
+		}
+	} else if re, err = mv.regexps.get(param); err != nil {
+		return nil, err
+	}
+
+	return func(v interface{}) error {
+		return matchRegexp(v, re)
+	}, nil
+}
+
+// merge merges the rules into the list. A rule replaces the one
+// with the same name, the rules following the dive marker replace
+// the element rules of the list.
+func (tl tagList) merge(rules tagList) tagList {
+	own, elems := tl.cut(tagDive)
+	ruleOwn, ruleElems := rules.cut(tagDive)
+	if len(ruleElems) > 0 {
+		elems = ruleElems
+	}
+
+	res := append(tagList{}, own...)
+	for _, r := range ruleOwn {
+		replaced := false
+		for i := range res {
+			if res[i].Name == r.Name {
+				res[i], replaced = r, true
+			}
+		}
+		if !replaced {
+			res = append(res, r)
+		}
+	}
+
+	return append(res, elems...)
+}
+
+// cut splits the list before the first marker
+func (tl tagList) cut(marker string) (before, after tagList) {
+	for i, t := range tl {
+		if t.Name == marker {
+			return tl[:i], tl[i:]
+		}
+	}
+	return tl, nil
+}
+
+// split splits the list into sections separated by the marker
+func (tl tagList) split(marker string) []tagList {
+	sections := []tagList{{}}
+	for _, t := range tl {
+		if t.Name == marker {
+			sections = append(sections, tagList{})
+			continue
+		}
+		sections[len(sections)-1] = append(sections[len(sections)-1], t)
+	}
+
+	return sections
+}
+
+// splitKeys splits the section of map element rules into the key
+// rules enclosed in the keys and endkeys markers and the value rules.
+func (tl tagList) splitKeys() (keys, values tagList, err error) {
+	if len(tl) == 0 || tl[0].Name != tagKeys {
+		return nil, tl, nil
+	}
+
+	for i, t := range tl {
+		if t.Name == tagEndKeys {
+			return tl[1:i], tl[i+1:], nil
+		}
+	}
+
+	return nil, nil, ErrBadParameter
+}
+
 // parseTags parses all individual tags found within a struct tag.
-// TODO: caching?
 func (mv *Validator) parseTags(t string) (tagList, error) {
-	match := tagRegexp.FindAllStringSubmatch(t, -1)
+	return mv.expandTags(t, nil)
+}
 
+// expandTags parses the tags replacing the rule aliases by the
+// rules they stand for. seen holds the aliases being expanded.
+func (mv *Validator) expandTags(t string, seen map[string]bool) (tagList, error) {
 	tags := make(tagList, 0)
+	for _, chunk := range splitMarkers(t) {
+		if tagMarkers[chunk] {
+			tags = append(tags, tag{Name: chunk})
+			continue
+		}
+
+		// regular tags between the aliases are parsed together
+		var rest []string
+		for _, item := range splitItems(chunk) {
+			name := aliasName(item)
+			if seen[name] {
+				return tagList{}, ErrAliasCycle
+			}
+			expansion, exists := mv.lookupAlias(name)
+			if !exists {
+				rest = append(rest, item)
+				continue
+			}
+
+			var err error
+			if tags, err = appendTags(tags, strings.Join(rest, ",")); err != nil {
+				return tagList{}, err
+			}
+			rest = rest[:0]
+
+			if seen == nil {
+				seen = make(map[string]bool)
+			}
+			seen[name] = true
+			expanded, err := mv.expandTags(expansion, seen)
+			delete(seen, name)
+			if err != nil {
+				return tagList{}, err
+			}
+			tags = append(tags, expanded...)
+		}
+
+		var err error
+		if tags, err = appendTags(tags, strings.Join(rest, ",")); err != nil {
+			return tagList{}, err
+		}
+	}
+
+	return tags, nil
+}
+
+// appendTags parses the chunk of regular tags and appends
+// them to the list
+func appendTags(tags tagList, chunk string) (tagList, error) {
+	match := tagRegexp.FindAllStringSubmatch(chunk, -1)
 	for _, group := range match {
 		tg := tag{}
 		tg.Name = strings.Trim(group[1], " ")
@@ -406,8 +1845,78 @@ func (mv *Validator) parseTags(t string) (tagList, error) {
 		}
 
 		tags = append(tags, tg)
+	}
+	return tags, nil
+}
 
+// aliasName returns the name of the tag item if it may refer to
+// an alias: a bare name or a name with an empty parameter.
+func aliasName(item string) string {
+	item = strings.TrimSpace(item)
+	i := strings.IndexByte(item, '=')
+	if i < 0 {
+		return item
+	}
+	if p := strings.Trim(item[i+1:], " '"); p != "" {
+		return ""
 	}
+	return strings.TrimSpace(item[:i])
+}
 
-	return tags, nil
+// splitItems splits the chunk of tags by commas outside of
+// single quotes
+func splitItems(chunk string) []string {
+	var (
+		items  []string
+		quoted bool
+		start  int
+	)
+	for i := 0; i < len(chunk); i++ {
+		switch chunk[i] {
+		case '\'':
+			quoted = !quoted
+		case ',':
+			if !quoted {
+				items = append(items, chunk[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(items, chunk[start:])
+}
+
+// splitMarkers splits the struct tag into markers and chunks of
+// regular tags between them. Commas inside single quotes don't
+// separate tags.
+func splitMarkers(t string) []string {
+	var (
+		chunks []string
+		quoted bool
+		start  int // start of the current chunk
+		item   int // start of the current tag
+	)
+	for i := 0; i <= len(t); i++ {
+		if i < len(t) {
+			if t[i] == '\'' {
+				quoted = !quoted
+			}
+			if t[i] != ',' || quoted {
+				continue
+			}
+		}
+
+		if name := strings.TrimSpace(t[item:i]); tagMarkers[name] {
+			if chunk := strings.TrimSuffix(t[start:item], ","); strings.TrimSpace(chunk) != "" {
+				chunks = append(chunks, chunk)
+			}
+			chunks = append(chunks, name)
+			start = i + 1
+		}
+		item = i + 1
+	}
+	if start < len(t) && strings.Trim(t[start:], ", ") != "" {
+		chunks = append(chunks, t[start:])
+	}
+
+	return chunks
 }
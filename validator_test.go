@@ -1,8 +1,21 @@
 package validator
 
 import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/x88/null"
@@ -135,3 +148,1268 @@ func Example() {
 	// Output: less than min
 	// not one of 2,3,4,5
 }
+
+func TestValidator_PlanCache(t *testing.T) {
+	type testStruct struct {
+		A string `validate:"notzz=''"`
+		B int    `validate:"min=1"`
+	}
+
+	v := NewValidator()
+	assert.Equal(t, ErrUnknownTag, v.Validate(testStruct{A: "ZZ"})["A"])
+
+	// registering a function must invalidate the cached plan
+	assert.Nil(t, v.SetValidationFunc("notzz", func(val interface{}, _ string) error {
+		if val.(string) == "ZZ" {
+			return ErrInvalidValue
+		}
+		return nil
+	}))
+	errs := v.Validate(testStruct{A: "ZZ"})
+	assert.Equal(t, ErrInvalidValue, errs["A"])
+	assert.Equal(t, ErrMin, errs["B"])
+
+	assert.True(t, v.Validate(&testStruct{A: "AA", B: 1}).IsEmpty())
+
+	// the plan is keyed by the tag name as well
+	errs = v.WithTag("other").Validate(testStruct{A: "ZZ"})
+	assert.True(t, errs.IsEmpty())
+
+	// configuring a child keeps the plans of the parent
+	st := reflect.TypeOf(testStruct{})
+	plan := v.planFor(st)
+	child := v.Extend()
+	assert.Nil(t, child.RegisterPattern("digits", "^[0-9]+$"))
+	assert.Same(t, plan, v.planFor(st))
+	assert.Same(t, plan, v.WithMode(AllErrors).planFor(st))
+	assert.NotSame(t, plan, child.planFor(st))
+
+	// while configuring the parent invalidates the plans of the child
+	childPlan := child.planFor(st)
+	assert.Same(t, childPlan, child.planFor(st))
+	assert.Nil(t, v.RegisterPattern("letters", "^[a-z]+$"))
+	assert.NotSame(t, childPlan, child.planFor(st))
+	assert.NotSame(t, plan, v.planFor(st))
+}
+
+func TestValidator_Regexp(t *testing.T) {
+	v := NewValidator()
+	assert.NotNil(t, v.RegisterPattern("bad", "[a-"))
+	assert.Nil(t, v.RegisterPattern("username", "^[a-z0-9_]{3,40}$"))
+
+	testStruct := struct {
+		Login   string `validate:"regexp=@username"`
+		Code    string `validate:"regexp=^[A-Z]+$"`
+		Unknown string `validate:"regexp=@unknown"`
+		Invalid string `validate:"regexp=[a-"`
+	}{
+		Login: "Bad Login",
+		Code:  "ABC",
+	}
+
+	errs := v.Validate(testStruct)
+	assert.Equal(t, ErrRegexp, errs["Login"])
+	assert.Nil(t, errs["Code"])
+	assert.True(t, errors.Is(errs["Unknown"], ErrBadParameter))
+	assert.True(t, errors.Is(errs["Invalid"], ErrBadParameter))
+	assert.Contains(t, errs["Invalid"].Error(), "missing closing ]")
+
+	assert.Nil(t, v.Valid("good_login", "regexp=@username"))
+	assert.Equal(t, ErrUnsupported, v.Valid(42, "regexp=@username").(ErrorArray)[0])
+
+	// failed compilations are memoized too
+	_, err := v.regexps.get("[a-")
+	assert.Equal(t, errs["Invalid"], err)
+
+	// tag errors are found before the validation
+	type nested struct {
+		Items []struct {
+			Code string `validate:"regexp=(a"`
+		}
+	}
+	err = v.Check(&nested{})
+	assert.True(t, errors.Is(err, ErrBadParameter))
+	assert.Contains(t, err.(ErrorMap), "Items.Code")
+	err = v.Check(testStruct)
+	assert.Len(t, err.(ErrorMap), 2)
+	assert.NoError(t, v.Check(struct {
+		Login string `validate:"regexp=@username"`
+	}{}))
+}
+
+func TestValidator_RuleFactory(t *testing.T) {
+	v := NewValidator()
+	assert.Nil(t, v.SetRuleFactory("divisible", func(param string) (Checker, error) {
+		d, err := asInt(param)
+		if err != nil || d == 0 {
+			return nil, ErrBadParameter
+		}
+		return func(val interface{}) error {
+			if val.(int)%int(d) != 0 {
+				return ErrInvalidValue
+			}
+			return nil
+		}, nil
+	}))
+
+	testStruct := struct {
+		Even   int    `validate:"divisible=2"`
+		Bad    int    `validate:"divisible=0"`
+		Uint   uint   `validate:"min=2,max=4"`
+		Len    string `validate:"len=3"`
+		BadMin int    `validate:"min=foo"`
+	}{
+		Even: 3,
+		Uint: 5,
+		Len:  "abcd",
+	}
+
+	errs := v.Validate(testStruct)
+	assert.Equal(t, ErrInvalidValue, errs["Even"])
+	assert.Equal(t, ErrBadParameter, errs["Bad"])
+	assert.Equal(t, ErrMax, errs["Uint"])
+	assert.Equal(t, ErrLen, errs["Len"])
+	assert.Equal(t, ErrBadParameter, errs["BadMin"])
+
+	assert.Nil(t, v.Valid(uint8(3), "in='1,2,3'"))
+
+	// parameters which don't fit the type fail when the rules are built
+	assert.Equal(t, ErrBadParameter, v.Valid(-1, "min=1.5"))
+	assert.Equal(t, ErrBadParameter, v.Valid(uint(1), "max=-1"))
+	assert.Equal(t, ErrBadParameter, v.Valid(2, "in='1,2.5'"))
+	assert.Nil(t, v.Valid(2.5, "in='1,2.5'"))
+	assert.Equal(t, ErrorMap{"Ratio": ErrBadParameter, "Codes": ErrBadParameter}, v.Check(struct {
+		Ratio int      `validate:"min=1.5"`
+		Codes []string `validate:"len=x"`
+		Score float64  `validate:"max=1.5"`
+	}{}))
+}
+
+func TestValidator_Extend(t *testing.T) {
+	parent := NewValidator()
+	child := parent.WithTag("child")
+	notFoo := func(val interface{}, _ string) error {
+		if val == "foo" {
+			return ErrInvalidValue
+		}
+		return nil
+	}
+
+	// rules set on a child don't leak into the parent
+	assert.Nil(t, child.SetValidationFunc("notfoo", notFoo))
+	assert.Equal(t, ErrUnknownTag, parent.Valid("foo", "notfoo=''"))
+	assert.Equal(t, ErrInvalidValue, child.Valid("foo", "notfoo=''").(ErrorArray)[0])
+
+	// rules set on a parent later are inherited
+	assert.Nil(t, parent.SetValidationFunc("notbar", notFoo))
+	assert.Equal(t, ErrInvalidValue, child.Valid("foo", "notbar=''").(ErrorArray)[0])
+
+	// builtin rules can be removed locally
+	assert.Nil(t, child.SetValidationFunc("min", nil))
+	assert.Equal(t, ErrUnknownTag, child.Valid(1, "min=2"))
+	assert.Equal(t, ErrMin, parent.Valid(1, "min=2").(ErrorArray)[0])
+
+	// named patterns are layered the same way
+	assert.Nil(t, child.RegisterPattern("digits", "^[0-9]+$"))
+	assert.True(t, errors.Is(parent.Valid("12", "regexp=@digits"), ErrBadParameter))
+	assert.Nil(t, child.Valid("12", "regexp=@digits"))
+}
+
+func TestValidator_Concurrent(t *testing.T) {
+	type testStruct struct {
+		A string `validate:"min=2,regexp=^[a-z]+$"`
+		B int    `validate:"max=10"`
+	}
+
+	v := NewValidator()
+	done := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		go func() {
+			defer func() { done <- struct{}{} }()
+			for j := 0; j < 100; j++ {
+				errs := v.WithTag("validate").Validate(testStruct{A: "a", B: 11})
+				assert.Equal(t, ErrMin, errs["A"])
+				assert.Equal(t, ErrMax, errs["B"])
+			}
+		}()
+	}
+	for j := 0; j < 100; j++ {
+		assert.Nil(t, v.SetValidationFunc(fmt.Sprintf("rule%d", j), notZero))
+	}
+	for i := 0; i < 4; i++ {
+		<-done
+	}
+}
+
+func TestValidator_ValidateCtx(t *testing.T) {
+	type ctxKey struct{}
+	type testStruct struct {
+		Limit int `validate:"tenantmax=''"`
+		Other int `validate:"min=1"`
+	}
+
+	v := NewValidator()
+	assert.Nil(t, v.SetValidationFuncCtx("tenantmax", func(ctx context.Context, val interface{}, _ string) error {
+		if val.(int) > ctx.Value(ctxKey{}).(int) {
+			return ErrMax
+		}
+		return nil
+	}))
+
+	ctx := context.WithValue(context.Background(), ctxKey{}, 10)
+	errs := v.ValidateCtx(ctx, testStruct{Limit: 11, Other: 1})
+	assert.Equal(t, ErrMax, errs["Limit"])
+	assert.Nil(t, v.ValidCtx(ctx, 10, "tenantmax=''"))
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	errs = v.ValidateCtx(cancelled, testStruct{Limit: 11})
+	assert.Equal(t, context.Canceled, errs["_summary"])
+	assert.Nil(t, errs["Other"])
+	assert.Equal(t, context.Canceled, v.ValidCtx(cancelled, 1, "min=2"))
+}
+
+func TestValidator_CrossField(t *testing.T) {
+	type period struct {
+		Start time.Time
+		End   *time.Time
+	}
+	now := time.Now()
+	before := now.Add(-time.Hour)
+
+	testStruct := struct {
+		Password        string
+		PasswordConfirm string    `validate:"eqfield=Password,attr=password_confirm,msg_eqfield=must match {param}"`
+		Username        string    `validate:"nefield=Password"`
+		Min             int       `validate:"ltfield=Max"`
+		Max             uint8     `validate:"gtfield=Min"`
+		Tags            []string  `validate:"gtfield=Period.Start"`
+		Deadline        time.Time `validate:"gtfield=Period.End"`
+		Period          period
+		Nick            string
+		Name            string `validate:"gtfield=Nick"`
+		Unknown         int    `validate:"eqfield=Nope"`
+	}{
+		Password:        "secret",
+		PasswordConfirm: "secret1",
+		Username:        "secret",
+		Min:             3,
+		Max:             4,
+		Tags:            []string{"a"},
+		Deadline:        before,
+		Period:          period{Start: before, End: &now},
+		Nick:            "b",
+		Name:            "aa",
+	}
+
+	errs := Validate(testStruct)
+	assert.Equal(t, "must match Password", errs["password_confirm"].Error())
+	assert.Equal(t, ErrNeField, errs["Username"])
+	assert.Nil(t, errs["Min"])
+	assert.Nil(t, errs["Max"])
+	assert.Equal(t, ErrUnsupported, errs["Tags"])
+	assert.Equal(t, ErrGtField, errs["Deadline"])
+	// strings are compared by value, not by length
+	assert.Equal(t, ErrGtField, errs["Name"])
+	assert.True(t, errors.Is(errs["Unknown"], ErrBadParameter))
+
+	// unknown fields are found when the plan is built
+	err := Check(testStruct)
+	assert.Len(t, err.(ErrorMap), 1)
+	assert.Contains(t, err.(ErrorMap)["Unknown"].Error(), "unknown field")
+
+	assert.Equal(t, ErrUnsupported, Valid(1, "eqfield=Other").(ErrorArray)[0])
+
+	// fields promoted through a nil embedded pointer are missing
+	type base struct {
+		Password string
+	}
+	type form struct {
+		*base
+		Confirm string `validate:"eqfield=Password"`
+	}
+	assert.NotPanics(t, func() { errs = Validate(form{Confirm: "x"}) })
+	assert.True(t, errors.Is(errs["Confirm"], ErrBadParameter))
+	assert.Nil(t, Validate(form{base: &base{Password: "x"}, Confirm: "x"}).Err())
+	assert.Equal(t, ErrEqField, Validate(form{base: &base{Password: "y"}, Confirm: "x"})["Confirm"])
+}
+
+func TestValidator_Conditional(t *testing.T) {
+	type account struct {
+		AccountType string `validate:"in='personal,business'"`
+		CompanyName string `validate:"required_if='AccountType business'"`
+		VATNumber   string `validate:"excluded_if='AccountType personal'"`
+		Phone       string
+		Email       string `validate:"required_without=Phone"`
+		Street      string `validate:"required_with='Zip City'"`
+		Zip         null.Int
+		City        string
+		Bad         string `validate:"required_if=AccountType"`
+	}
+
+	errs := Validate(account{AccountType: "business", Zip: null.IntFrom(0)})
+	assert.Equal(t, ErrZeroValue, errs["CompanyName"])
+	assert.Nil(t, errs["VATNumber"])
+	assert.Equal(t, ErrZeroValue, errs["Email"])
+	assert.Nil(t, errs["Street"])
+	assert.Equal(t, ErrBadParameter, errs["Bad"])
+
+	errs = Validate(account{AccountType: "personal", VATNumber: "X1", Phone: "1", City: "Moscow"})
+	assert.Nil(t, errs["CompanyName"])
+	assert.Equal(t, ErrExcluded, errs["VATNumber"])
+	assert.Nil(t, errs["Email"])
+	assert.Equal(t, ErrZeroValue, errs["Street"])
+
+	// fields promoted through a nil embedded pointer are missing
+	type base struct {
+		Kind string
+	}
+	type company struct {
+		*base
+		Name string `validate:"required_if='Kind business'"`
+		VAT  string `validate:"required_with=Kind"`
+		Tax  string `validate:"required_without=Kind"`
+	}
+	assert.NotPanics(t, func() { errs = Validate(company{}) })
+	assert.Equal(t, ErrorMap{"Tax": ErrZeroValue}, errs)
+	errs = Validate(company{base: &base{Kind: "business"}})
+	assert.Equal(t, ErrorMap{"Name": ErrZeroValue, "VAT": ErrZeroValue}, errs)
+}
+
+func TestCompare(t *testing.T) {
+	ts := time.Date(2020, 5, 29, 12, 0, 0, 0, time.UTC)
+	data := []struct {
+		v     interface{}
+		param string
+		err   error
+	}{
+		{true, "true", nil},
+		{false, "true", ErrCompare},
+		{false, "yes", ErrBadParameter},
+		{int8(42), "42", nil},
+		{uint(42), "0x2a", nil},
+		{uint(42), "-1", ErrBadParameter},
+		{1.5, "1.5", nil},
+		{1.5, "1.6", ErrCompare},
+		{"abc", "abc", nil},
+		{ts, "2020-05-29T12:00:00Z", nil},
+		{ts, "2020-05-29", ErrBadParameter},
+		{[]int{}, "1", ErrUnsupported},
+		{nil, "1", ErrCompare},
+	}
+
+	for _, row := range data {
+		err := Valid(row.v, "compare="+row.param)
+		if errs, ok := err.(ErrorArray); ok {
+			err = errs[0]
+		}
+		assert.Equal(t, row.err, err, fmt.Sprintf("%#v", row))
+	}
+
+	testStruct := struct {
+		AgreeTerms bool   `validate:"attr=agree_terms,compare=true"`
+		Role       string `validate:"ncompare=admin"`
+	}{
+		Role: "admin",
+	}
+	errs := Validate(testStruct)
+	assert.Equal(t, ErrCompare, errs["agree_terms"])
+	assert.Equal(t, ErrNCompare, errs["Role"])
+}
+
+func TestValidator_MessagePlaceholders(t *testing.T) {
+	testStruct := struct {
+		Name  string `validate:"attr=name,label='First name',min=3,max=15,msg_min={label} should be between {min} and {max} characters"`
+		Sex   string `validate:"in='male,female',msg_in={field} must be one of {allowed} but not {value}"`
+		Card  string `validate:"mask=4,len=16,msg_len={value} is not a card number"`
+		Other string `validate:"len=1,msg_len={unknown} {value}"`
+	}{
+		Name:  "ab",
+		Sex:   "none",
+		Card:  "123456789",
+		Other: "ab",
+	}
+
+	errs := Validate(testStruct)
+	assert.Equal(t, "First name should be between 3 and 15 characters", errs["name"].Error())
+	assert.Equal(t, "Sex must be one of male, female but not none", errs["Sex"].Error())
+	assert.Equal(t, "*****6789 is not a card number", errs["Card"].Error())
+	assert.Equal(t, "{unknown} ab", errs["Other"].Error())
+
+	// rule placeholders are not reported without custom messages
+	assert.Equal(t, ErrInvalidValue, Valid("none", "in='male,female'").(ErrorArray)[0])
+	assert.Equal(t, ErrBadParameter, Valid("none", "mask=x,min=1"))
+}
+
+func TestValidator_Mode(t *testing.T) {
+	type nested struct {
+		A int `validate:"min=1"`
+	}
+	testStruct := struct {
+		Name   string `validate:"min=3,regexp=^[a-z]+$,msg_regexp=lowercase only"`
+		Age    int    `validate:"min=18"`
+		Nested nested
+	}{
+		Name: "A",
+	}
+
+	errs := WithMode(AllErrors).Validate(testStruct)
+	assert.Equal(t, ErrorArray{
+		RuleErr{Rule: "min", Err: ErrMin},
+		RuleErr{Rule: "regexp", Err: errors.New("lowercase only")},
+	}, errs["Name"])
+	assert.Equal(t, ErrorArray{RuleErr{Rule: "min", Err: ErrMin}}, errs["Nested.A"])
+
+	// the default validator is not affected
+	assert.Equal(t, ErrMin, Validate(testStruct)["Name"])
+
+	errs = WithMode(FailFast).Validate(testStruct)
+	assert.Equal(t, 1, len(errs))
+	assert.Equal(t, ErrMin, errs["Name"])
+
+	v := NewValidator()
+	v.SetMode(AllErrors | FailFast)
+	errs = v.Validate(&testStruct)
+	assert.Equal(t, 1, len(errs))
+	assert.Equal(t, 2, len(errs["Name"].(ErrorArray)))
+}
+
+func TestValidator_Dive(t *testing.T) {
+	type address struct {
+		City string `validate:"attr=city,min=2"`
+	}
+	testStruct := struct {
+		Tags      []string   `validate:"attr=tags,max=4,dive,min=2,regexp=^[a-z]+$"`
+		Matrix    [][]int    `validate:"dive,min=1,dive,max=9"`
+		Addresses []*address `validate:"attr=addresses"`
+		Untagged  [2]address
+		Quoted    []string `validate:"dive,in='a,dive,b'"`
+		BadDive   int      `validate:"dive,min=1"`
+	}{
+		Tags:      []string{"go", "Go", "x", "rust", "c"},
+		Matrix:    [][]int{{1, 10}, {}},
+		Addresses: []*address{{City: "Moscow"}, nil, {City: "M"}},
+		Untagged:  [2]address{{City: "Tver"}, {}},
+		Quoted:    []string{"dive", "c"},
+	}
+
+	errs := Validate(testStruct)
+	assert.Equal(t, ErrMax, errs["tags"])
+	assert.Nil(t, errs["tags[0]"])
+	assert.Equal(t, ErrRegexp, errs["tags[1]"])
+	assert.Equal(t, ErrMin, errs["tags[2]"])
+	assert.Equal(t, ErrMin, errs["tags[4]"])
+	assert.Equal(t, ErrMax, errs["Matrix[0][1]"])
+	assert.Equal(t, ErrMin, errs["Matrix[1]"])
+	assert.Equal(t, ErrMin, errs["addresses[2].city"])
+	assert.Equal(t, ErrMin, errs["Untagged[1].city"])
+	assert.Nil(t, errs["Quoted[0]"])
+	assert.Equal(t, ErrInvalidValue, errs["Quoted[1]"])
+	assert.Equal(t, ErrUnsupported, errs["BadDive"])
+	assert.Equal(t, 10, len(errs))
+
+	assert.Equal(t, ErrUnsupported, Valid([]int{1}, "dive,min=1"))
+}
+
+func TestValidator_Map(t *testing.T) {
+	type item struct {
+		Price int `validate:"attr=price,min=1"`
+	}
+	testStruct := struct {
+		Labels map[string]string `validate:"attr=labels,max=3,dive,keys,min=2,regexp=^[a-z]+$,endkeys,notempty=''"`
+		Items  map[int]*item     `validate:"attr=items"`
+		Counts map[string]int    `validate:"dive,max=10"`
+		Groups map[string][]int  `validate:"dive,min=1,dive,max=5"`
+		Bad    map[string]int    `validate:"dive,keys,min=1"`
+	}{
+		Labels: map[string]string{"env": "", "x": "y", "Team": "core"},
+		Items:  map[int]*item{10: {Price: 0}, 2: {Price: 1}, 1: {Price: -1}},
+		Counts: map[string]int{"a": 11},
+		Groups: map[string][]int{"g": {6}, "h": {}},
+	}
+
+	errs := Validate(testStruct)
+	assert.Equal(t, ErrZeroValue, errs["labels[env]"])
+	assert.Equal(t, ErrMin, errs["labels[x]"])
+	assert.Equal(t, ErrRegexp, errs["labels[Team]"])
+	assert.Equal(t, ErrMin, errs["items[1].price"])
+	assert.Equal(t, ErrMin, errs["items[10].price"])
+	assert.Nil(t, errs["items[2].price"])
+	assert.Equal(t, ErrMax, errs["Counts[a]"])
+	assert.Equal(t, ErrMax, errs["Groups[g][0]"])
+	assert.Equal(t, ErrMin, errs["Groups[h]"])
+	assert.Equal(t, ErrBadParameter, errs["Bad"])
+	assert.Equal(t, 9, len(errs))
+
+	// keys and values are reported under the same key
+	errs = WithMode(AllErrors).Validate(struct {
+		Labels map[string]string `validate:"dive,keys,min=2,endkeys,min=2"`
+	}{
+		Labels: map[string]string{"a": "b"},
+	})
+	assert.Equal(t, ErrorArray{RuleErr{"min", ErrMin}, RuleErr{"min", ErrMin}}, errs["Labels[a]"])
+
+	// keys are processed in the sorted order
+	errs = WithMode(FailFast).Validate(struct {
+		Items map[int]*item
+	}{testStruct.Items})
+	assert.Equal(t, ErrorMap{"Items[1].price": ErrMin}, errs)
+}
+
+func TestValidator_Collections(t *testing.T) {
+	type order struct {
+		ID    int `validate:"attr=id,min=1"`
+		Items []struct {
+			Qty int `validate:"attr=qty,min=1"`
+		} `validate:"attr=items"`
+	}
+	orders := []order{{ID: 1}, {ID: 0}}
+	orders[0].Items = append(orders[0].Items, struct {
+		Qty int `validate:"attr=qty,min=1"`
+	}{Qty: 0})
+
+	errs := Validate(orders)
+	assert.Equal(t, ErrorMap{"[0].items[0].qty": ErrMin, "[1].id": ErrMin}, errs)
+
+	errs = Validate(&map[string]*order{"a": {ID: 1}, "b": {ID: -1}})
+	assert.Equal(t, ErrorMap{"[b].id": ErrMin}, errs)
+
+	assert.True(t, Validate([2]order{{ID: 1}, {ID: 2}}).IsEmpty())
+	assert.Equal(t, ErrUnsupported, Validate([]string{"a"})["_summary"])
+	assert.Equal(t, ErrUnsupported, Validate((*order)(nil))["_summary"])
+}
+
+type EmbeddedBase struct {
+	ID int `validate:"attr=id,min=1"`
+}
+
+type embeddedAudit struct {
+	Author string `validate:"attr=author,notempty=''"`
+}
+
+type embeddedNode struct {
+	*embeddedNode
+	Name string `validate:"attr=name,notempty=''"`
+}
+
+func TestValidator_Embedded(t *testing.T) {
+	type user struct {
+		EmbeddedBase
+		*embeddedAudit
+		Name string `validate:"attr=name,notempty=''"`
+	}
+	errs := Validate(user{embeddedAudit: &embeddedAudit{}})
+	assert.Equal(t, ErrorMap{"id": ErrMin, "author": ErrZeroValue, "name": ErrZeroValue}, errs)
+
+	// nil embedded pointers are skipped
+	assert.Equal(t, ErrorMap{"id": ErrMin}, Validate(user{Name: "a"}))
+
+	type prefixed struct {
+		EmbeddedBase `validate:"attr=base"`
+		Audit        *EmbeddedBase `validate:"notempty=''"`
+	}
+	errs = Validate(prefixed{})
+	assert.Equal(t, ErrorMap{"base.id": ErrMin, "Audit": ErrZeroValue}, errs)
+
+	type required struct {
+		*EmbeddedBase `validate:"notempty=''"`
+	}
+	assert.Equal(t, ErrorMap{"EmbeddedBase": ErrZeroValue}, Validate(required{}))
+	assert.Equal(t, ErrorMap{"id": ErrMin}, Validate(required{&EmbeddedBase{}}))
+
+	// the shallower field hides the promoted one
+	type shadowed struct {
+		EmbeddedBase
+		ID string `validate:"attr=id,len=2"`
+	}
+	assert.Equal(t, ErrorMap{"id": ErrLen}, Validate(shadowed{ID: "a"}))
+
+	node := embeddedNode{embeddedNode: &embeddedNode{}}
+	assert.Equal(t, ErrorMap{"name": ErrZeroValue}, Validate(node))
+
+	// ambiguous fields at the same depth are dropped
+	type probeA struct {
+		X int `validate:"min=1"`
+		A int `validate:"min=1"`
+	}
+	type probeB struct {
+		X string `validate:"notempty=''"`
+	}
+	type probe struct {
+		probeA
+		probeB
+	}
+	assert.Equal(t, ErrorMap{"A": ErrMin}, Validate(probe{}))
+}
+
+type leafMoney struct {
+	Cents int64
+}
+
+func TestValidator_Leaf(t *testing.T) {
+	type event struct {
+		At     time.Time  `validate:"attr=at,notempty='',min=2020-01-01T00:00:00Z"`
+		Until  *time.Time `validate:"attr=until,notempty=''"`
+		Amount *big.Int   `validate:"attr=amount,min=1,max=100000000000000000000"`
+		Rate   big.Float  `validate:"attr=rate,max=0.5"`
+		Link   url.URL    `validate:"attr=link,notempty='',regexp=^https://"`
+		Net    net.IPNet  `validate:"attr=net,notempty=''"`
+	}
+	amount, _ := new(big.Int).SetString("100000000000000000001", 10)
+	link, _ := url.Parse("http://example.com")
+	e := event{
+		At:     time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC),
+		Amount: amount,
+		Rate:   *big.NewFloat(0.75),
+		Link:   *link,
+	}
+	errs := Validate(e)
+	assert.Equal(t, ErrorMap{
+		"at":     ErrMin,
+		"until":  ErrZeroValue,
+		"amount": ErrMax,
+		"rate":   ErrMax,
+		"link":   ErrRegexp,
+		"net":    ErrZeroValue,
+	}, errs)
+
+	_, ipNet, _ := net.ParseCIDR("10.0.0.0/8")
+	until := time.Now()
+	e = event{
+		At:     time.Now(),
+		Until:  &until,
+		Amount: big.NewInt(1),
+		Link:   url.URL{Scheme: "https", Host: "example.com"},
+		Net:    *ipNet,
+	}
+	assert.True(t, Validate(e).IsEmpty())
+	assert.Equal(t, ErrorArray{ErrZeroValue}, Valid(time.Time{}, "notempty=''"))
+
+	// value objects
+	type order struct {
+		Total leafMoney `validate:"attr=total,min=100"`
+	}
+	v := NewValidator()
+	// walked as a nested struct until registered
+	assert.True(t, v.Validate(order{}).IsEmpty())
+	assert.NoError(t, v.RegisterLeafType(leafMoney{}, func(v interface{}) interface{} {
+		return v.(leafMoney).Cents
+	}))
+	assert.Equal(t, ErrorMap{"total": ErrMin}, v.Validate(order{Total: leafMoney{99}}))
+	assert.True(t, v.Validate(order{Total: leafMoney{100}}).IsEmpty())
+	assert.Error(t, v.RegisterLeafType(1, nil))
+}
+
+type selfRange struct {
+	From int `validate:"attr=from,min=0"`
+	To   int `validate:"attr=to"`
+}
+
+func (r *selfRange) Validate() error {
+	if r.From > r.To {
+		return errors.New("from after to")
+	}
+	return nil
+}
+
+type selfBooking struct {
+	Range  selfRange   `validate:"attr=range"`
+	Extra  []selfRange `validate:"attr=extra"`
+	Guests int         `validate:"attr=guests"`
+}
+
+func (b selfBooking) ValidateStruct() ErrorMap {
+	if b.Guests > 2 {
+		return ErrorMap{"guests": ErrMax}
+	}
+	return nil
+}
+
+func TestValidator_Self(t *testing.T) {
+	b := selfBooking{
+		Range:  selfRange{From: 2, To: 1},
+		Extra:  []selfRange{{From: 1, To: 2}, {From: 3, To: 2}},
+		Guests: 3,
+	}
+	errs := Validate(&b)
+	assert.Equal(t, 3, len(errs))
+	assert.Equal(t, ErrMax, errs["guests"])
+	assert.EqualError(t, errs["range"], "from after to")
+	assert.EqualError(t, errs["extra[1]"], "from after to")
+
+	errs = Validate(selfRange{From: -1, To: -2})
+	assert.Equal(t, ErrorMap{"from": ErrMin, "": errors.New("from after to")}, errs)
+
+	// struct methods run only if the fields are valid
+	errs = WithMode(FailFast).Validate(selfRange{From: -1, To: -2})
+	assert.Equal(t, ErrorMap{"from": ErrMin}, errs)
+
+	// methods promoted through nil embedded pointers are skipped
+	assert.NotPanics(t, func() { errs = Validate(selfPromoted{Name: "a"}) })
+	assert.True(t, errs.IsEmpty())
+	errs = Validate(selfPromoted{selfCode: &selfCode{}, selfNote: &selfNote{}, Name: "a"})
+	assert.Equal(t, ErrorMap{"": errors.New("no code"), "note": ErrZeroValue}, errs)
+}
+
+type selfCode struct {
+	Code string
+}
+
+func (c selfCode) Validate() error {
+	if c.Code == "" {
+		return errors.New("no code")
+	}
+	return nil
+}
+
+type selfNote struct {
+	Note string
+}
+
+func (n *selfNote) ValidateStruct() ErrorMap {
+	if n.Note == "" {
+		return ErrorMap{"note": ErrZeroValue}
+	}
+	return nil
+}
+
+type selfPromoted struct {
+	*selfCode
+	*selfNote
+	Name string `validate:"notempty=''"`
+}
+
+type externalUser struct {
+	Email string
+	Name  string `validate:"min=2,max=10"`
+	Tags  []string
+	Age   int `validate:"min=18"`
+}
+
+func TestValidator_StructRules(t *testing.T) {
+	v := NewValidator()
+	assert.NoError(t, v.RegisterStructRules(externalUser{}, map[string]string{
+		"Email": "attr=email,notempty=''",
+		"Name":  "max=3",
+		"Tags":  "max=2,dive,min=1",
+		"Age":   "-",
+	}))
+
+	u := externalUser{Name: "abcd", Tags: []string{"a", ""}, Age: 1}
+	errs := v.Validate(&u)
+	assert.Equal(t, ErrorMap{"email": ErrZeroValue, "Name": ErrMax, "Tags[1]": ErrMin}, errs)
+
+	errs = v.Validate(externalUser{Email: "a", Name: "a"})
+	assert.Equal(t, ErrorMap{"Name": ErrMin}, errs)
+
+	// the default validator is not affected
+	assert.Equal(t, ErrorMap{"Name": ErrMin, "Age": ErrMin}, Validate(externalUser{Name: "a"}))
+
+	assert.Error(t, v.RegisterStructRules(externalUser{}, map[string]string{"Phone": "min=1"}))
+	assert.Error(t, v.RegisterStructRules("", nil))
+}
+
+type (
+	typedEmail   string
+	typedPercent float64
+)
+
+func TestValidator_TypeRules(t *testing.T) {
+	v := NewValidator()
+	assert.NoError(t, v.RegisterTypeRules(reflect.TypeOf(typedEmail("")), "regexp=^[a-z]+@[a-z.]+$,max=20"))
+	assert.NoError(t, v.RegisterTypeRules(reflect.TypeOf(typedPercent(0)), "min=0,max=100"))
+
+	type contact struct {
+		Email typedEmail `validate:"attr=email"`
+	}
+	type account struct {
+		Owner    typedEmail                  `validate:"attr=owner,max=5"`
+		Backup   *typedEmail                 `validate:"attr=backup"`
+		Emails   []typedEmail                `validate:"attr=emails"`
+		Shares   map[typedEmail]typedPercent `validate:"attr=shares"`
+		Contact  contact                     `validate:"attr=contact"`
+		Discount typedPercent
+	}
+	backup := typedEmail("b@b")
+	a := account{
+		Owner:    "owner@example.com",
+		Backup:   &backup,
+		Emails:   []typedEmail{"a@b.c", "bad"},
+		Shares:   map[typedEmail]typedPercent{"a@b": 10, "c@d": 120},
+		Contact:  contact{Email: "?"},
+		Discount: -1,
+	}
+	errs := v.Validate(a)
+	assert.Equal(t, ErrorMap{
+		"owner":         ErrMax,
+		"emails[1]":     ErrRegexp,
+		"shares[c@d]":   ErrMax,
+		"contact.email": ErrRegexp,
+		"Discount":      ErrMin,
+	}, errs)
+
+	assert.Equal(t, ErrorArray{ErrMax}, v.Valid(typedPercent(101), "min=1"))
+	assert.Equal(t, ErrorMap{"[0]": ErrMin}, v.Validate([]typedPercent{-1}))
+
+	// the default validator is not affected
+	assert.Equal(t, ErrorMap{"owner": ErrMax}, Validate(a))
+	assert.Error(t, v.RegisterTypeRules(nil, "min=1"))
+}
+
+func TestValidator_Alias(t *testing.T) {
+	v := NewValidator()
+	assert.NoError(t, v.RegisterAlias("username", "min=3,max=8,regexp=^[a-z0-9_]+$"))
+	assert.NoError(t, v.RegisterAlias("login", "notempty='',username"))
+
+	type user struct {
+		Name  string `validate:"attr=name,username,msg_max='at most {max} characters'"`
+		Login string `validate:"attr=login,login=''"`
+		Nick  string `validate:"attr=nick,max=10,username"`
+	}
+	errs := v.Validate(user{Name: "abcdefghij", Login: "", Nick: "A"})
+	assert.EqualError(t, errs["name"], "at most 8 characters")
+	assert.Equal(t, ErrZeroValue, errs["login"])
+	assert.Equal(t, ErrMin, errs["nick"])
+
+	assert.True(t, v.Validate(user{Name: "abc", Login: "a_b_c", Nick: "xyz"}).IsEmpty())
+	assert.Equal(t, ErrorArray{ErrRegexp}, v.Valid("AB_C", "username"))
+
+	// cycles are detected on registration
+	assert.Equal(t, ErrAliasCycle, v.RegisterAlias("a", "min=1,a"))
+	assert.NoError(t, v.RegisterAlias("b", "min=1"))
+	assert.NoError(t, v.RegisterAlias("a", "b,max=3"))
+	assert.Equal(t, ErrAliasCycle, v.RegisterAlias("b", "a"))
+	assert.Equal(t, ErrBadParameter, v.RegisterAlias("items", "dive,min=1"))
+	assert.Error(t, v.RegisterAlias("", "min=1"))
+
+	// other validators don't know the aliases
+	assert.Equal(t, ErrUnknownTag, Validate(user{})["login"])
+}
+
+type optionalString struct {
+	value string
+	set   bool
+}
+
+// valuerArray is a driver.Valuer which isn't a nullable value
+type valuerArray []string
+
+func (a valuerArray) Value() (driver.Value, error) {
+	return "{" + strings.Join(a, ",") + "}", nil
+}
+
+func TestValidator_Nullable(t *testing.T) {
+	type profile struct {
+		Name    sql.NullString `validate:"attr=name,min=3,regexp=^[a-z]+$"`
+		Nick    null.String    `validate:"attr=nick,notempty=''"`
+		Age     sql.NullInt32  `validate:"attr=age,min=18,max=150"`
+		Active  sql.NullBool   `validate:"attr=active,compare=true"`
+		Born    sql.NullTime   `validate:"attr=born,max=2020-01-01T00:00:00Z"`
+		Score   null.Int       `validate:"attr=score,in='1,2,3'"`
+		Title   optionalString `validate:"attr=title,notempty='',max=5"`
+		MinAge  int            `validate:"attr=min_age,gtfield=Age"`
+		Country string         `validate:"attr=country,required_with=Nick"`
+	}
+	p := profile{
+		Name:    sql.NullString{String: "ab", Valid: true},
+		Nick:    null.StringFrom("nick"),
+		Age:     sql.NullInt32{Int32: 16, Valid: true},
+		Active:  sql.NullBool{Bool: false, Valid: true},
+		Born:    sql.NullTime{Time: time.Now(), Valid: true},
+		Score:   null.IntFrom(4),
+		Title:   optionalString{value: "abcdef", set: true},
+		MinAge:  16,
+		Country: "",
+	}
+
+	v := NewValidator()
+	assert.NoError(t, v.RegisterWrapper(optionalString{}, func(v interface{}) (interface{}, bool) {
+		o := v.(optionalString)
+		return o.value, o.set
+	}))
+	errs := v.Validate(p)
+	assert.Equal(t, ErrorMap{
+		"name":    ErrMin,
+		"age":     ErrMin,
+		"active":  ErrCompare,
+		"born":    ErrMax,
+		"score":   ErrInvalidValue,
+		"title":   ErrMax,
+		"min_age": ErrGtField,
+		"country": ErrZeroValue,
+	}, errs)
+
+	// invalid nulls are absent, only the presence rules apply
+	errs = v.Validate(profile{MinAge: 1})
+	assert.Equal(t, ErrorMap{
+		"nick":    ErrZeroValue,
+		"title":   ErrZeroValue,
+		"active":  ErrCompare,
+		"min_age": ErrGtField,
+	}, errs)
+	assert.Equal(t, ErrorArray{ErrZeroValue}, v.Valid(sql.NullString{}, "notempty=''"))
+	assert.Nil(t, v.Valid(sql.NullString{}, "min=3,regexp=^[a-z]+$"))
+	assert.Nil(t, v.Valid(sql.NullString{String: "abc", Valid: true}, "len=3"))
+	assert.Error(t, v.RegisterWrapper(&optionalString{}, nil))
+
+	// other valuers are validated as they are
+	assert.Equal(t, ErrorArray{ErrZeroValue}, v.Valid(valuerArray{}, "notempty=''"))
+	assert.Nil(t, v.Valid(valuerArray{"a", "b", "c"}, "max=5"))
+}
+
+func TestErrorMap_Errors(t *testing.T) {
+	type inner struct {
+		Code string `validate:"attr=code,len=2"`
+	}
+	type outer struct {
+		Name  string `validate:"attr=name,min=3,regexp=^[a-z]+$"`
+		Age   int    `validate:"attr=age,min=18"`
+		Inner inner  `validate:"attr=inner"`
+	}
+
+	errs := WithMode(AllErrors).Validate(outer{Name: "A", Age: 20, Inner: inner{Code: "abc"}})
+	var err error = errs
+	assert.Equal(t, "inner.code: invalid length; name: less than min", err.Error())
+	assert.True(t, errors.Is(err, ErrMin))
+	assert.True(t, errors.Is(err, ErrRegexp))
+	assert.True(t, errors.Is(fmt.Errorf("signup: %w", err), ErrLen))
+	assert.False(t, errors.Is(err, ErrMax))
+
+	var ruleErr RuleErr
+	assert.True(t, errors.As(err, &ruleErr))
+	assert.Equal(t, "len", ruleErr.Rule)
+	assert.Len(t, errs.Unwrap(), 2)
+	assert.Equal(t, errs.Error(), errs.String())
+
+	// TextErr unwraps to the inner error
+	assert.Equal(t, ErrMin.Err, errors.Unwrap(ErrMin))
+
+	assert.NoError(t, Validate(outer{Name: "abc", Age: 18, Inner: inner{Code: "ab"}}).Err())
+	assert.Error(t, errs.Err())
+}
+
+func TestValidator_FieldError(t *testing.T) {
+	type item struct {
+		Card string `validate:"attr=card,len=16,mask=4,msg_len='{value} is not a card number'"`
+	}
+	type order struct {
+		Qty   int    `validate:"attr=qty,min=1,max=10"`
+		Color string `validate:"in='red,green'"`
+		Items []item `validate:"attr=items"`
+	}
+	o := order{Qty: 0, Color: "blue", Items: []item{{Card: "123456789"}}}
+
+	errs := WithMode(Detailed).Validate(o)
+	assert.Len(t, errs, 3)
+
+	var fe *FieldError
+	assert.True(t, errors.As(errs["qty"], &fe))
+	assert.Equal(t, &FieldError{
+		Path:    "qty",
+		Attr:    "qty",
+		Rule:    "min",
+		Param:   "1",
+		Value:   0,
+		Default: ErrMin.Error(),
+		Err:     ErrMin,
+	}, fe)
+	assert.True(t, errors.Is(errs["qty"], ErrMin))
+
+	fe = errs["Color"].(*FieldError)
+	assert.Equal(t, "in", fe.Rule)
+	assert.Equal(t, "red, green", fe.Placeholders["allowed"])
+	assert.True(t, errors.Is(fe, ErrInvalidValue))
+
+	fe = errs["items[0].card"].(*FieldError)
+	assert.Equal(t, "items[0].card", fe.Path)
+	assert.Equal(t, "card", fe.Attr)
+	assert.Equal(t, "*****6789", fe.Value)
+	assert.Equal(t, "*****6789 is not a card number", fe.Error())
+	assert.Equal(t, ErrLen.Error(), fe.Default)
+	assert.True(t, errors.Is(errs, ErrLen))
+
+	// every failing rule is reported with AllErrors
+	errs = WithMode(Detailed | AllErrors).Validate(order{Qty: 11, Color: "red"})
+	arr := errs["qty"].(ErrorArray)
+	assert.Len(t, arr, 1)
+	assert.Equal(t, "max", arr[0].(*FieldError).Rule)
+}
+
+func TestErrorMap_Order(t *testing.T) {
+	type item struct {
+		Qty int `validate:"min=1,max=10"`
+	}
+	type address struct {
+		Zip  string `validate:"len=5"`
+		City string `validate:"notempty=''"`
+	}
+	type order struct {
+		Zeta    int `validate:"min=1"`
+		Items   []item
+		Address address
+		Alpha   int `validate:"min=1"`
+	}
+	items := make([]item, 11)
+	for i := range items {
+		items[i].Qty = 1
+	}
+	items[10].Qty, items[2].Qty = 0, 11
+	o := order{Items: items, Address: address{Zip: "1"}}
+
+	// the declaration order is returned explicitly
+	fields := []string{"Zeta", "Items[2].Qty", "Items[10].Qty", "Address.Zip", "Address.City", "Alpha"}
+	for i := 0; i < 10; i++ {
+		_, ordered := ValidateOrdered(o)
+		assert.Equal(t, fields, ordered)
+	}
+
+	// the map lists the errors in the path order
+	fields = []string{"Address.City", "Address.Zip", "Alpha", "Items[2].Qty", "Items[10].Qty", "Zeta"}
+	assert.Equal(t, fields, Validate(o).Fields())
+	assert.Equal(t, "Address.City: zero value; Address.Zip: invalid length; Alpha: less than min; "+
+		"Items[2].Qty: greater than max; Items[10].Qty: less than min; Zeta: less than min", Validate(o).Error())
+
+	data, err := json.Marshal(Validate(o))
+	assert.NoError(t, err)
+	var decoded ErrorMap
+	assert.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, fields, decoded.Fields())
+
+	errs := ErrorMap{
+		"_summary":      ErrUnsupported,
+		"name":          ErrMin,
+		"items[10].qty": ErrMin,
+		"items[2].qty":  ErrorArray{ErrMin, ErrMax},
+		"items":         ErrLen,
+		"":              errors.New("invalid order"),
+		"address.city":  ErrZeroValue,
+	}
+	fields = []string{"", "address.city", "items", "items[2].qty", "items[10].qty", "name", "_summary"}
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, fields, errs.Fields())
+	}
+
+	var each []string
+	errs.Each(func(field string, err error) {
+		each = append(each, field+"="+err.Error())
+	})
+	assert.Equal(t, []string{
+		"=invalid order",
+		"address.city=zero value",
+		"items=invalid length",
+		"items[2].qty=less than min",
+		"items[2].qty=greater than max",
+		"items[10].qty=less than min",
+		"name=less than min",
+		"_summary=unsupported type",
+	}, each)
+
+	assert.Equal(t, "invalid order\n"+
+		"address.city: zero value\n"+
+		"items: invalid length\n"+
+		"items[2].qty: less than min\n"+
+		"items[2].qty: greater than max\n"+
+		"items[10].qty: less than min\n"+
+		"name: less than min\n"+
+		"_summary: unsupported type\n", errs.Summary())
+	assert.Equal(t, "", ErrorMap{}.Summary())
+	assert.Equal(t, "invalid order; address.city: zero value; items: invalid length; "+
+		"items[2].qty: less than min; items[10].qty: less than min; "+
+		"name: less than min; _summary: unsupported type", errs.Error())
+}
+
+func TestErrorMap_JSON(t *testing.T) {
+	type item struct {
+		Qty int `validate:"attr=qty,min=1,max=10"`
+	}
+	type order struct {
+		Name  string `validate:"attr=name,min=3,regexp=^[a-z]+$,msg_regexp='lowercase only'"`
+		Color string `validate:"attr=color,in='red,green'"`
+		Items []item `validate:"attr=items"`
+	}
+	o := order{Name: "A", Color: "blue", Items: []item{{Qty: 0}, {Qty: 11}}}
+
+	for _, mode := range []Mode{0, AllErrors, Detailed, Detailed | AllErrors} {
+		errs := WithMode(mode).Validate(o)
+		data, err := json.Marshal(errs)
+		assert.NoError(t, err)
+
+		var decoded ErrorMap
+		assert.NoError(t, json.Unmarshal(data, &decoded))
+		assert.Equal(t, errs.Error(), decoded.Error())
+		assert.Equal(t, len(errs), len(decoded))
+		assert.True(t, errors.Is(decoded, ErrMin))
+		assert.True(t, errors.Is(decoded["items[1].qty"], ErrMax))
+		if mode&AllErrors != 0 {
+			assert.IsType(t, ErrorArray{}, decoded["name"])
+		}
+		if mode&Detailed != 0 {
+			var fe *FieldError
+			assert.True(t, errors.As(decoded["color"], &fe))
+			assert.Equal(t, "in", fe.Rule)
+			assert.Equal(t, "red, green", fe.Placeholders["allowed"])
+			assert.Equal(t, "blue", fe.Value)
+		}
+	}
+
+	data, err := json.Marshal(Validate(o))
+	assert.NoError(t, err)
+	assert.JSONEq(t, `[
+		{"field":"color","message":"invalid value","code":"invalid_value"},
+		{"field":"items[0].qty","message":"less than min","code":"min"},
+		{"field":"items[1].qty","message":"greater than max","code":"max"},
+		{"field":"name","message":"less than min","code":"min"}
+	]`, string(data))
+
+	data, err = json.Marshal(WithMode(AllErrors).Validate(order{Name: "Abc", Color: "red"}))
+	assert.NoError(t, err)
+	assert.JSONEq(t, `[{"field":"name","rule":"regexp","message":"lowercase only","array":true}]`, string(data))
+
+	text, err := Validate(order{Name: "abc", Color: "red", Items: []item{{}}}).MarshalText()
+	assert.NoError(t, err)
+	assert.Equal(t, "items[0].qty: less than min\n", string(text))
+
+	// errors holding values that are not comparable have no code
+	data, err = json.Marshal(ErrorMap{"x": TextErr{ErrorArray{ErrMin}}, "y": ErrorArray{ErrMin}})
+	assert.NoError(t, err)
+	assert.JSONEq(t, `[
+		{"field":"x","message":"less than min"},
+		{"field":"y","message":"less than min","code":"min","array":true}
+	]`, string(data))
+}
+
+func TestErrorMap_Problem(t *testing.T) {
+	errs := ErrorMap{
+		"age":  ErrMin,
+		"name": ErrorArray{RuleErr{Rule: "len", Err: ErrLen}, RuleErr{Rule: "regexp", Err: errors.New("letters only")}},
+	}
+
+	rec := httptest.NewRecorder()
+	assert.NoError(t, errs.WriteProblem(rec))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Equal(t, ProblemContentType, rec.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{
+		"type": "about:blank",
+		"title": "Bad Request",
+		"status": 400,
+		"invalid-params": [
+			{"name": "age", "reason": "less than min", "code": "min"},
+			{"name": "name", "reason": "invalid length", "rule": "len", "code": "len"},
+			{"name": "name", "reason": "letters only", "rule": "regexp"}
+		]
+	}`, rec.Body.String())
+}
+
+func TestTranslate(t *testing.T) {
+	type item struct {
+		Qty int `validate:"min=2"`
+	}
+	type order struct {
+		Name  string `validate:"notempty='',msg_notempty=errors.form.name"`
+		Code  string `validate:"len=3"`
+		Items []item `validate:"min=3"`
+	}
+
+	catalog := NewCatalog()
+	assert.NoError(t, catalog.LoadJSON(strings.NewReader(`{
+		"en": {"errors.form.name": "name is required", "min": "at least {param}"},
+		"ru": {
+			"errors.form.name": "укажите имя",
+			"Items.min": {"one": "нужен {count} товар", "few": "нужно {count} товара", "many": "нужно {count} товаров"},
+			"Code.len": "код из трёх символов",
+			"min": "не меньше {min}"
+		}
+	}`)))
+	v := NewValidator()
+	v.SetTranslator(catalog)
+
+	o := order{Code: "ab", Items: []item{{Qty: 1}}}
+	errs := v.WithMode(Detailed).ValidateCtx(WithLocale(context.Background(), "ru_RU"), o)
+	assert.Equal(t, "укажите имя", errs["Name"].Error())
+	assert.Equal(t, "нужно 3 товара", errs["Items"].Error())
+	assert.Equal(t, "не меньше 2", errs["Items[0].Qty"].Error())
+	assert.Equal(t, "код из трёх символов", errs["Code"].Error())
+	assert.True(t, errors.Is(errs["Items"], ErrMin))
+	assert.IsType(t, &FieldError{}, errs["Code"])
+
+	// the mode is kept, the messages are looked up with the details
+	errs = v.ValidateCtx(WithLocale(context.Background(), "ru"), o)
+	assert.Equal(t, "укажите имя", errs["Name"].Error())
+	assert.Equal(t, "код из трёх символов", errs["Code"].Error())
+	assert.Equal(t, "нужно 3 товара", errs["Items"].Error())
+	assert.Equal(t, "не меньше 2", errs["Items[0].Qty"].Error())
+	assert.Equal(t, &LocalizedErr{Err: ErrLen, Message: "код из трёх символов"}, errs["Code"])
+	assert.Equal(t, &LocalizedErr{Err: errors.New("errors.form.name"), Message: "укажите имя"}, errs["Name"])
+
+	errs = v.WithMode(AllErrors).ValidateCtx(WithLocale(context.Background(), "ru"), o)
+	assert.Equal(t, ErrorArray{RuleErr{Rule: "len", Err: &LocalizedErr{Err: ErrLen, Message: "код из трёх символов"}}}, errs["Code"])
+
+	// field keys of the rules sharing an error
+	type person struct {
+		Email string `validate:"attr=email,notempty=''"`
+		Sex   string `validate:"attr=sex,in='m,f'"`
+		Phone string `validate:"attr=phone,required_without=Email"`
+	}
+	assert.NoError(t, catalog.LoadJSON(strings.NewReader(`{"en": {
+		"email.notempty": "enter your email",
+		"sex.in": "choose m or f",
+		"phone.required_without": "enter a phone or an email"
+	}}`)))
+	errs = v.ValidateCtx(WithLocale(context.Background(), "en"), person{Sex: "x"})
+	assert.Equal(t, "enter your email", errs["email"].Error())
+	assert.Equal(t, "choose m or f", errs["sex"].Error())
+	assert.Equal(t, "enter a phone or an email", errs["phone"].Error())
+	assert.True(t, errors.Is(errs["sex"], ErrInvalidValue))
+
+	errs = v.Translate(v.Validate(person{Sex: "x"}), "en")
+	assert.Equal(t, "enter your email", errs["email"].Error())
+	assert.Equal(t, "choose m or f", errs["sex"].Error())
+	assert.Equal(t, "enter a phone or an email", errs["phone"].Error())
+
+	errs = v.Translate(v.WithMode(Detailed).Validate(o), "en")
+	assert.Equal(t, "name is required", errs["Name"].Error())
+	assert.Equal(t, "at least 3", errs["Items"].Error())
+
+	// builtin catalogs, without the rule details
+	errs = Translate(Validate(o), "ru")
+	assert.Equal(t, "errors.form.name", errs["Name"].Error())
+	assert.Equal(t, "имеет неверную длину", errs["Code"].Error())
+	assert.Equal(t, "слишком мало", errs["Items"].Error())
+	assert.True(t, errors.Is(errs["Code"], ErrLen))
+
+	data, err := json.Marshal(errs)
+	assert.NoError(t, err)
+	var decoded ErrorMap
+	assert.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, "имеет неверную длину", decoded["Code"].Error())
+	assert.True(t, errors.Is(decoded["Code"], ErrLen))
+
+	errs = WithMode(Detailed).ValidateCtx(WithLocale(context.Background(), "en"), o)
+	assert.Equal(t, "must have a length of 3", errs["Code"].Error())
+	assert.Equal(t, "must be at least 2", errs["Items[0].Qty"].Error())
+
+	data, err = json.Marshal(errs)
+	assert.NoError(t, err)
+	decoded = nil
+	assert.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, "must have a length of 3", decoded["Code"].Error())
+	assert.True(t, errors.Is(decoded["Code"], ErrLen))
+
+	errs = ValidateCtx(WithLocale(context.Background(), "en"), o)
+	assert.Equal(t, "must have a length of 3", errs["Code"].Error())
+	assert.True(t, errors.Is(errs["Code"], ErrLen))
+
+	// errors holding values that are not comparable keep their messages
+	assert.NotPanics(t, func() {
+		errs = Translate(ErrorMap{"x": TextErr{ErrorArray{ErrMin}}}, "en")
+	})
+	assert.Equal(t, "less than min", errs["x"].Error())
+}
+
+func TestPluralForm(t *testing.T) {
+	for n, form := range map[float64]string{1: "one", 2: "few", 5: "many", 11: "many", 21: "one", 112: "many", 1.5: "other"} {
+		assert.Equal(t, form, pluralForm("ru", n), n)
+	}
+	assert.Equal(t, "one", pluralForm("en", 1))
+	assert.Equal(t, "other", pluralForm("en", 2))
+}